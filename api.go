@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// apiPrefix is the path below which the current version of the REST API is
+// served.
+const apiPrefix = "/api/v1"
+
+// registerAPI registers the handlers of the REST API. The catalog and audit
+// endpoints are served below the API prefix as well as at their old paths.
+func registerAPI() {
+	http.HandleFunc("GET "+apiPrefix+"/basepaths", apiBasePathsHandler)
+	http.HandleFunc("GET "+apiPrefix+"/basepaths/{id}/tree", apiTreeHandler)
+	http.HandleFunc("GET "+apiPrefix+"/basepaths/{id}/days/{yyyy}/{mm}/{dd}/files", apiDayFilesHandler)
+	http.HandleFunc("GET "+apiPrefix+"/disks", apiDisksHandler)
+	http.HandleFunc("GET "+apiPrefix+"/templates", apiTemplatesHandler)
+	http.HandleFunc("GET "+apiPrefix+"/catalog", catalogHandler)
+	http.HandleFunc("GET "+apiPrefix+"/catalog/stats", catalogStatsHandler)
+	http.HandleFunc("GET "+apiPrefix+"/audit", auditHandler)
+}
+
+// writeJSON writes v as the JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// APIBasePath is a configured basepath. The ID is its position in the
+// configuration, starting at 1.
+type APIBasePath struct {
+	ID              int          `json:"id"`
+	Path            string       `json:"path"`
+	DestinationRoot string       `json:"destination_root"`
+	Disks           []string     `json:"disks"` // Disks whose cleaning deletes below the destination root
+	Retention       APIRetention `json:"retention"`
+	Archive         string       `json:"archive,omitempty"` // Archive directory
+	Weight          int          `json:"weight"`
+	Priority        int          `json:"priority"`
+}
+
+// APIRetention is the retention of a basepath, see StructRetention.
+type APIRetention struct {
+	MaxDays int  `json:"max_days"`
+	MinDays int  `json:"min_days"`
+	Forever bool `json:"forever"`
+}
+
+// apiBasePathsHandler serves GET /api/v1/basepaths.
+func apiBasePathsHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+
+	basepaths := []APIBasePath{}
+	for i, bp := range cfg.BasePaths {
+		root := bp.destinationRoot()
+		weight, priority := cfg.streamSettings(root)
+		basepath := APIBasePath{
+			ID:              i + 1,
+			Path:            bp.Path,
+			DestinationRoot: root,
+			Disks:           []string{},
+			Retention:       APIRetention{bp.Retention.MaxDays, bp.Retention.MinDays, bp.Retention.Forever},
+			Archive:         cfg.archiveFor(root),
+			Weight:          weight,
+			Priority:        priority,
+		}
+		for _, thedisk := range cfg.Disks {
+			if onDisk(root, thedisk.DiskName) {
+				basepath.Disks = append(basepath.Disks, thedisk.DiskName)
+			}
+		}
+		basepaths = append(basepaths, basepath)
+	}
+	writeJSON(w, basepaths)
+}
+
+// basePathOf returns the basepath with the id in the request path. It writes
+// a 404 response and returns false when there is none.
+func basePathOf(cfg *Config, w http.ResponseWriter, r *http.Request) (StructBasePath, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 || id > len(cfg.BasePaths) {
+		http.Error(w, "No basepath "+r.PathValue("id"), http.StatusNotFound)
+		return StructBasePath{}, false
+	}
+	return cfg.BasePaths[id-1], true
+}
+
+// apiTreeHandler serves GET /api/v1/basepaths/{id}/tree, the years, months and
+// days below the basepath with their number of files and bytes.
+func apiTreeHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+
+	bp, ok := basePathOf(cfg, w, r)
+	if !ok {
+		return
+	}
+	tree, err := buildTree(cfg, bp.destinationRoot())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, tree)
+}
+
+// APIFile is a file in a day directory.
+type APIFile struct {
+	Path    string    `json:"path"` // Relative to the destination root
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+}
+
+// apiDayFilesHandler serves GET /api/v1/basepaths/{id}/days/{yyyy}/{mm}/{dd}/files,
+// the files of every directory of that day below the basepath.
+func apiDayFilesHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+
+	bp, ok := basePathOf(cfg, w, r)
+	if !ok {
+		return
+	}
+	dateStr := r.PathValue("yyyy") + r.PathValue("mm") + r.PathValue("dd")
+	if _, err := time.Parse("20060102", dateStr); err != nil || len(dateStr) != 8 {
+		http.Error(w, "Invalid date "+dateStr, http.StatusBadRequest)
+		return
+	}
+	dateKey, _ := strconv.ParseInt(dateStr, 10, 64)
+
+	root := bp.destinationRoot()
+	directories, err := cfg.dayDirectories(root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	files := []APIFile{}
+	found := false
+	for _, dir := range directories {
+		if dir.ModTime != dateKey {
+			continue
+		}
+		found = true
+		err := filepath.WalkDir(dir.Path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, APIFile{Path: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime().UTC()})
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if !found {
+		http.Error(w, "No directory for "+dateStr, http.StatusNotFound)
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	writeJSON(w, files)
+}
+
+// APIDisk is a configured disk with its current space and inodes.
+type APIDisk struct {
+	Name         string   `json:"name"`
+	Mount        string   `json:"mount"`
+	TotalBytes   float64  `json:"total_bytes"`
+	FreeBytes    float64  `json:"free_bytes"`
+	TotalInodes  float64  `json:"total_inodes"`
+	FreeInodes   float64  `json:"free_inodes"`
+	Limits       string   `json:"limits"`        // Minimum and target free space and inodes
+	NeedsCleanup bool     `json:"needs_cleanup"` // Below a minimum
+	Eviction     string   `json:"eviction"`
+	BasePaths    []string `json:"basepaths"` // Destination roots cleaned for this disk
+	Error        string   `json:"error,omitempty"`
+}
+
+// apiDisksHandler serves GET /api/v1/disks.
+func apiDisksHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+
+	disks := []APIDisk{}
+	for _, thedisk := range cfg.Disks {
+		apiDisk := APIDisk{
+			Name:      thedisk.DiskName,
+			Eviction:  thedisk.Eviction,
+			BasePaths: cfg.diskBasePaths(thedisk.DiskName),
+		}
+		if apiDisk.Eviction == "" {
+			apiDisk.Eviction = EvictionOldest
+		}
+		if apiDisk.BasePaths == nil {
+			apiDisk.BasePaths = []string{}
+		}
+		apiDisk.Mount, _ = mountPointOf(thedisk.DiskName)
+		limits, err := limitsFor(thedisk)
+		if err == nil {
+			apiDisk.Limits = limits.String()
+		}
+		state, statErr := statDisk(thedisk.DiskName)
+		if statErr != nil {
+			err = statErr
+		} else {
+			apiDisk.TotalBytes, apiDisk.FreeBytes = state.TotalBytes, state.FreeBytes
+			apiDisk.TotalInodes, apiDisk.FreeInodes = state.TotalInodes, state.FreeInodes
+			apiDisk.NeedsCleanup = limits.needsCleanup(state)
+		}
+		if err != nil {
+			apiDisk.Error = err.Error()
+		}
+		disks = append(disks, apiDisk)
+	}
+	writeJSON(w, disks)
+}
+
+// APITemplate is a configured file template. The ID is its position in the
+// configuration, starting at 1.
+type APITemplate struct {
+	ID           int            `json:"id"`
+	Name         string         `json:"name"` // File template or regex, as in the catalog
+	FileTemplate string         `json:"filetemplate,omitempty"`
+	Regex        string         `json:"regex,omitempty"`
+	StartDate    int            `json:"startdate"`
+	DateLayout   string         `json:"datelayout,omitempty"`
+	Destination  string         `json:"destination"`
+	Collision    string         `json:"collision"`
+	MaxGap       float64        `json:"max_gap_seconds"` // 0 when the stream is not watched
+	Stalled      bool           `json:"stalled"`
+	Stats        *TemplateStats `json:"stats,omitempty"` // From the catalog
+}
+
+// apiTemplatesHandler serves GET /api/v1/templates.
+func apiTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	stats := make(map[string]TemplateStats)
+	if all, err := catalog.stats(); err == nil {
+		for _, stat := range all {
+			stats[stat.Template] = stat
+		}
+	}
+	stalled := make(map[string]bool)
+	for _, alert := range getStreamAlerts() {
+		stalled[alert.Template] = true
+	}
+
+	cfg := currentConfig()
+
+	templates := []APITemplate{}
+	for i, template := range cfg.FileTemplates {
+		name := templateName(template)
+		apiTemplate := APITemplate{
+			ID:           i + 1,
+			Name:         name,
+			FileTemplate: template.FileTemplate,
+			Regex:        template.Regex,
+			StartDate:    template.StartDate,
+			DateLayout:   template.DateLayout,
+			Destination:  destinationLayout(template),
+			Collision:    template.Collision,
+			MaxGap:       maxGap(template).Seconds(),
+			Stalled:      stalled[name],
+		}
+		if apiTemplate.Collision == "" {
+			apiTemplate.Collision = CollisionOverwrite
+		}
+		if stat, ok := stats[name]; ok {
+			apiTemplate.Stats = &stat
+		}
+		templates = append(templates, apiTemplate)
+	}
+	writeJSON(w, templates)
+}
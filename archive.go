@@ -0,0 +1,355 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StructArchive configures where the day directories of a basepath are
+// archived before they are deleted.
+type StructArchive struct {
+	Directory string `yaml:"directory"`
+}
+
+// manifestName is the name of the manifest, the last entry of every bundle.
+const manifestName = "MANIFEST.json"
+
+// ArchiveManifest describes the contents of an archive bundle.
+type ArchiveManifest struct {
+	BasePath string        `json:"basepath"`
+	Day      string        `json:"day"` // Day directory relative to the basepath
+	Created  time.Time     `json:"created"`
+	Files    []ArchiveFile `json:"files"`
+}
+
+// ArchiveFile is a file in an archive bundle.
+type ArchiveFile struct {
+	Path    string    `json:"path"` // Relative to the basepath
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// archiveFor returns the archive directory of the basepaths filed below a
+// destination root, or "" when they are not archived.
+func (cfg *Config) archiveFor(root string) string {
+	for _, bp := range cfg.BasePaths {
+		if bp.destinationRoot() == root && bp.Archive != nil && bp.Archive.Directory != "" {
+			return bp.Archive.Directory
+		}
+	}
+	return ""
+}
+
+// removeDay deletes a day directory of size bytes, after archiving it when
+// its basepath has an archive directory. A day that cannot be archived is not
+// deleted. The rule and trigger of the deletion go into the audit log.
+func removeDay(cfg *Config, dir DirectoryInfo, size int64, rule, trigger string) error {
+	if archiveDir := cfg.archiveFor(dir.BasePath); archiveDir != "" {
+		bundle, err := archiveDay(dir, archiveDir)
+		auditLog.record(AuditEntry{Action: AuditArchive, Source: dir.Path, Destination: bundle, Size: size, Rule: rule, Trigger: trigger}, err)
+		if err != nil {
+			return fmt.Errorf("error archiving directory %s, not deleted: %v", dir.Path, err)
+		}
+	}
+	err := os.RemoveAll(dir.Path)
+	auditLog.record(AuditEntry{Action: AuditDeleteDay, Source: dir.Path, Size: size, Rule: rule, Trigger: trigger}, err)
+	if err != nil {
+		return fmt.Errorf("error deleting directory %s: %v", dir.Path, err)
+	}
+	if _, err := catalog.recordDeleted(dir.Path, time.Now()); err != nil {
+		fmt.Printf("Warning: failed to mark %s as deleted in the catalog: %v\n", dir.Path, err)
+	}
+	return nil
+}
+
+// archiveDay packs a day directory into a tar.gz bundle with a manifest in
+// archiveDir and returns the path of the bundle. The bundle is written to a
+// temporary file, synced to disk and verified against the manifest before it
+// is renamed into place.
+func archiveDay(dir DirectoryInfo, archiveDir string) (string, error) {
+	day, err := filepath.Rel(dir.BasePath, dir.Path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(archiveDir, 0755); err != nil {
+		return "", err
+	}
+	bundle := filepath.Join(archiveDir, strings.ReplaceAll(day, string(os.PathSeparator), "-")+".tar.gz")
+	if _, err := os.Lstat(bundle); err == nil {
+		bundle = uniquePath(bundle)
+	}
+
+	tmp := filepath.Join(archiveDir, "."+filepath.Base(bundle)+".tmp")
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	// Remove the temporary file on any failure below.
+	defer os.Remove(tmp)
+
+	manifest, err := writeBundle(out, dir.BasePath, dir.Path)
+	if err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return "", fmt.Errorf("fsync %s: %v", tmp, err)
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+
+	if _, err := verifyBundle(tmp); err != nil {
+		return "", fmt.Errorf("verifying %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, bundle); err != nil {
+		return "", err
+	}
+	syncDir(archiveDir)
+
+	var size int64
+	for _, file := range manifest.Files {
+		size += file.Size
+	}
+	fmt.Printf("Archived %s to %s (%d files, %d bytes)\n", dir.Path, bundle, len(manifest.Files), size)
+	return bundle, nil
+}
+
+// writeBundle writes the day directory dayPath as a tar.gz stream to w, with
+// the names relative to basePath and the manifest as last entry.
+func writeBundle(w io.Writer, basePath, dayPath string) (*ArchiveManifest, error) {
+	day, err := filepath.Rel(basePath, dayPath)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &ArchiveManifest{BasePath: basePath, Day: filepath.ToSlash(day), Created: time.Now().UTC()}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err = filepath.WalkDir(dayPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			fmt.Printf("Warning: not archiving %s, not a regular file\n", path)
+			return nil
+		}
+		rel, err := filepath.Rel(basePath, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+			return tw.WriteHeader(header)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		n, err := io.Copy(tw, io.TeeReader(f, h))
+		if err != nil {
+			return err
+		}
+		if n != info.Size() {
+			return fmt.Errorf("%s changed while archiving", path)
+		}
+		manifest.Files = append(manifest.Files, ArchiveFile{
+			Path:    header.Name,
+			Size:    n,
+			ModTime: info.ModTime().UTC(),
+			SHA256:  hex.EncodeToString(h.Sum(nil)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	header := &tar.Header{Name: manifestName, Mode: 0644, Size: int64(len(data)), ModTime: manifest.Created, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// readBundle calls fn for every entry of a tar.gz bundle.
+func readBundle(bundle string, fn func(header *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(bundle)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// verifyBundle checks every file in a bundle against its manifest and returns
+// the manifest.
+func verifyBundle(bundle string) (*ArchiveManifest, error) {
+	var manifest *ArchiveManifest
+	checksums := make(map[string]string)
+	err := readBundle(bundle, func(header *tar.Header, r io.Reader) error {
+		if header.Name == manifestName {
+			manifest = &ArchiveManifest{}
+			return json.NewDecoder(r).Decode(manifest)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		checksums[header.Name] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, errors.New("no manifest in bundle")
+	}
+	if len(checksums) != len(manifest.Files) {
+		return nil, fmt.Errorf("bundle holds %d files, manifest lists %d", len(checksums), len(manifest.Files))
+	}
+	for _, file := range manifest.Files {
+		if checksums[file.Path] != file.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch for %s", file.Path)
+		}
+	}
+	return manifest, nil
+}
+
+// restoreBundle verifies a bundle and unpacks it below root, by default the
+// basepath it was archived from. Existing files are left alone.
+func restoreBundle(bundle, root string) error {
+	manifest, err := verifyBundle(bundle)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %v", bundle, err)
+	}
+	if root == "" {
+		root = manifest.BasePath
+	}
+
+	restored, skipped := 0, 0
+	err = readBundle(bundle, func(header *tar.Header, r io.Reader) error {
+		if header.Name == manifestName {
+			return nil
+		}
+		if !filepath.IsLocal(filepath.FromSlash(strings.TrimSuffix(header.Name, "/"))) {
+			return fmt.Errorf("unsafe path %s in bundle", header.Name)
+		}
+		target := filepath.Join(root, filepath.FromSlash(header.Name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			return os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			if _, err := os.Lstat(target); err == nil {
+				fmt.Printf("Skipping %s, it already exists\n", target)
+				skipped++
+				return nil
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			tmp := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".restore")
+			out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			defer os.Remove(tmp)
+			if _, err := io.Copy(out, r); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+			if err := os.Chtimes(tmp, header.ModTime, header.ModTime); err != nil {
+				return err
+			}
+			if err := os.Rename(tmp, target); err != nil {
+				return err
+			}
+			restored++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s (%s) to %s: %d file(s) restored, %d skipped\n", bundle, manifest.Day, root, restored, skipped)
+	return nil
+}
+
+// runRestore implements the restore command and returns the exit status.
+func runRestore(bundle, root string) int {
+	if bundle == "" {
+		fmt.Println("Error: no bundle given")
+		return 2
+	}
+	if err := restoreBundle(bundle, root); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StructAuditLog configures the audit log. The log is rotated when it grows
+// beyond MaxSize MiB, Keep rotated files are kept.
+type StructAuditLog struct {
+	Path    string `yaml:"path"`
+	MaxSize int    `yaml:"maxsize"`
+	Keep    int    `yaml:"keep"`
+}
+
+// Defaults of the audit log.
+const (
+	defaultAuditLog     = "audit.log"
+	defaultAuditMaxSize = 10 // MiB
+	defaultAuditKeep    = 5
+)
+
+// Audit actions.
+const (
+	AuditMove       = "move"
+	AuditQuarantine = "quarantine"
+	AuditDelete     = "delete"     // An unmatched or duplicate file
+	AuditReplace    = "replace"    // A file at the destination overwritten by a move
+	AuditDeleteDay  = "delete-day" // A whole day directory
+	AuditArchive    = "archive"
+	AuditPurge      = "purge" // A quarantined file past its retention
+)
+
+// AuditEntry is one filesystem action in the audit log.
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination,omitempty"`
+	Size        int64     `json:"size"`
+	Rule        string    `json:"rule"`              // Template or rule responsible for the action
+	Trigger     string    `json:"trigger,omitempty"` // Disk reading that triggered a deletion
+	Outcome     string    `json:"outcome"`           // "ok" or the error
+}
+
+// AuditLog is an append-only JSON-lines log of every filesystem action. A nil
+// audit log records nothing.
+type AuditLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+// auditLog is opened at startup; it stays nil when the log is unavailable.
+var auditLog *AuditLog
+
+// openAuditLog opens the audit log for appending.
+func openAuditLog(config StructAuditLog) (*AuditLog, error) {
+	l := &AuditLog{
+		path:    config.Path,
+		maxSize: int64(config.MaxSize) << 20,
+		keep:    config.Keep,
+	}
+	if l.path == "" {
+		l.path = defaultAuditLog
+	}
+	if l.maxSize <= 0 {
+		l.maxSize = defaultAuditMaxSize << 20
+	}
+	if l.keep <= 0 {
+		l.keep = defaultAuditKeep
+	}
+	if err := l.open(); err != nil {
+		return nil, fmt.Errorf("error opening audit log %s: %v", l.path, err)
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// rotate renames the log to path.1, path.1 to path.2 and so on, dropping the
+// oldest, and starts a new log.
+func (l *AuditLog) rotate() error {
+	l.file.Close()
+	l.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+	for n := l.keep - 1; n >= 1; n-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, n), fmt.Sprintf("%s.%d", l.path, n+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.open()
+}
+
+// record appends an entry to the audit log. The outcome is "ok" for a nil
+// error and the error otherwise.
+func (l *AuditLog) record(entry AuditEntry, err error) {
+	if l == nil {
+		return
+	}
+	entry.Time = time.Now().UTC()
+	entry.Outcome = "ok"
+	if err != nil {
+		entry.Outcome = err.Error()
+	}
+	data, jsonErr := json.Marshal(entry)
+	if jsonErr != nil {
+		fmt.Printf("Error writing audit log: %v\n", jsonErr)
+		return
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return
+	}
+	if l.size+int64(len(data)) > l.maxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			fmt.Printf("Error rotating audit log %s: %v\n", l.path, err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		fmt.Printf("Error writing audit log %s: %v\n", l.path, err)
+	}
+}
+
+// AuditQuery selects audit entries. Empty fields match everything.
+type AuditQuery struct {
+	Action string    // Exact action
+	Path   string    // Part of the source or destination
+	Since  time.Time // Entries at or after this time
+	Limit  int
+}
+
+// search returns the newest entries matching a query, newest first. The
+// rotated files are searched as well.
+func (l *AuditLog) search(query AuditQuery) ([]AuditEntry, error) {
+	if l == nil {
+		return nil, fmt.Errorf("audit log is not available")
+	}
+
+	// Open the files under the lock, so that a rotation cannot shift them
+	// between two reads, but read them without it: record is called with
+	// moveMutex held and must not wait for a search.
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	l.mu.Lock()
+	for n := l.keep; n >= 0; n-- {
+		path := l.path
+		if n > 0 {
+			path = fmt.Sprintf("%s.%d", l.path, n)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			l.mu.Unlock()
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	l.mu.Unlock()
+
+	// Read from the oldest rotated file to the current log, keeping the last
+	// Limit matches.
+	var entries []AuditEntry
+	for _, f := range files {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+				continue
+			}
+			if query.Action != "" && entry.Action != query.Action {
+				continue
+			}
+			if query.Path != "" && !strings.Contains(entry.Source, query.Path) && !strings.Contains(entry.Destination, query.Path) {
+				continue
+			}
+			if entry.Time.Before(query.Since) {
+				continue
+			}
+			entries = append(entries, entry)
+			if query.Limit > 0 && len(entries) > query.Limit {
+				entries = entries[1:]
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", f.Name(), err)
+		}
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	return entries, nil
+}
+
+// Close closes the audit log.
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// auditHandler serves GET /api/audit. The parameters action, path, since
+// (RFC 3339) and limit select the entries, see AuditQuery.
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query := AuditQuery{
+		Action: values.Get("action"),
+		Path:   values.Get("path"),
+		Limit:  200,
+	}
+	if since := values.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "Invalid since, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		query.Since = t
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		query.Limit = n
+	}
+
+	entries, err := auditLog.search(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
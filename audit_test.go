@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestAuditLogSearchWhileRecording(t *testing.T) {
+	l, err := openAuditLog(StructAuditLog{Path: filepath.Join(t.TempDir(), "audit.log"), Keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// Rotate after every few entries.
+	l.maxSize = 400
+
+	for i := 0; i < 20; i++ {
+		l.record(AuditEntry{Action: AuditMove, Source: fmt.Sprintf("/in/%02d", i)}, nil)
+	}
+	entries, err := l.search(AuditQuery{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sources []string
+	for _, entry := range entries {
+		sources = append(sources, entry.Source)
+	}
+	if fmt.Sprint(sources) != "[/in/19 /in/18 /in/17]" {
+		t.Errorf("search returned %v, want the 3 newest entries, newest first", sources)
+	}
+
+	// Searches run next to recording and rotating.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 20; i < 200; i++ {
+			l.record(AuditEntry{Action: AuditMove, Source: fmt.Sprintf("/in/%03d", i)}, nil)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := l.search(AuditQuery{Action: AuditMove}); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// defaultCatalog is the catalog file used when catalog is not configured.
+const defaultCatalog = "catalog.db"
+
+// Buckets of the catalog. files holds a CatalogEntry per destination path,
+// dates indexes the destination paths by product date.
+var (
+	filesBucket = []byte("files")
+	datesBucket = []byte("dates")
+)
+
+// CatalogEntry is a filed product.
+type CatalogEntry struct {
+	Filename    string     `json:"filename"`
+	Template    string     `json:"template"` // File template or regex that matched
+	Date        string     `json:"date"`     // Product date, YYYYMMDD
+	Size        int64      `json:"size"`
+	Arrival     time.Time  `json:"arrival"` // When the file was filed
+	BasePath    string     `json:"basepath"`
+	Destination string     `json:"destination"` // Full path of the filed file
+	Deleted     *time.Time `json:"deleted,omitempty"`
+}
+
+// Catalog is the persistent record of every filed product. A nil catalog
+// records nothing.
+type Catalog struct {
+	db *bolt.DB
+}
+
+// catalog is opened at startup; it stays nil when the catalog is unavailable.
+var catalog *Catalog
+
+// catalogPath returns the catalog file of a configuration.
+func catalogPath(config YAMLConfig) string {
+	if config.Catalog == "" {
+		return defaultCatalog
+	}
+	return config.Catalog
+}
+
+// openCatalog opens or creates the catalog file.
+func openCatalog(path string) (*Catalog, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening catalog %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{filesBucket, datesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initialising catalog %s: %v", path, err)
+	}
+	return &Catalog{db: db}, nil
+}
+
+// Close closes the catalog file.
+func (c *Catalog) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.db.Close()
+}
+
+// dateIndexKey returns the key of a destination in the dates bucket.
+func dateIndexKey(date, destination string) []byte {
+	return []byte(date + "\x00" + destination)
+}
+
+// recordFiled adds a filed product. A product filed again at the same
+// destination replaces the earlier entry.
+func (c *Catalog) recordFiled(entry CatalogEntry) error {
+	if c == nil {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		files, dates := tx.Bucket(filesBucket), tx.Bucket(datesBucket)
+		if old := files.Get([]byte(entry.Destination)); old != nil {
+			var previous CatalogEntry
+			if json.Unmarshal(old, &previous) == nil {
+				dates.Delete(dateIndexKey(previous.Date, previous.Destination))
+			}
+		}
+		if err := files.Put([]byte(entry.Destination), data); err != nil {
+			return err
+		}
+		return dates.Put(dateIndexKey(entry.Date, entry.Destination), nil)
+	})
+}
+
+// recordDeleted marks every product below dir as deleted and returns how
+// many were marked.
+func (c *Catalog) recordDeleted(dir string, when time.Time) (int, error) {
+	if c == nil {
+		return 0, nil
+	}
+	count := 0
+	prefix := []byte(strings.TrimSuffix(dir, string(os.PathSeparator)) + string(os.PathSeparator))
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		files := tx.Bucket(filesBucket)
+		type update struct{ key, value []byte }
+		var updates []update
+
+		cursor := files.Cursor()
+		for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			var entry CatalogEntry
+			if err := json.Unmarshal(value, &entry); err != nil || entry.Deleted != nil {
+				continue
+			}
+			entry.Deleted = &when
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			updates = append(updates, update{append([]byte(nil), key...), data})
+		}
+		// A bucket must not be modified while a cursor walks it.
+		for _, u := range updates {
+			if err := files.Put(u.key, u.value); err != nil {
+				return err
+			}
+		}
+		count = len(updates)
+		return nil
+	})
+	return count, err
+}
+
+// CatalogQuery selects catalog entries. Empty fields match everything.
+type CatalogQuery struct {
+	Name     string // Part of the filename
+	Template string // Exact file template or regex
+	From, To string // Product dates, YYYYMMDD, inclusive
+	Deleted  bool   // Include deleted products
+	Limit    int
+}
+
+// search returns the entries matching a query, ordered by product date.
+func (c *Catalog) search(query CatalogQuery) ([]CatalogEntry, error) {
+	if c == nil {
+		return nil, fmt.Errorf("catalog is not available")
+	}
+	entries := []CatalogEntry{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		files := tx.Bucket(filesBucket)
+		cursor := tx.Bucket(datesBucket).Cursor()
+
+		key, _ := cursor.First()
+		if query.From != "" {
+			key, _ = cursor.Seek([]byte(query.From))
+		}
+		for ; key != nil; key, _ = cursor.Next() {
+			date, destination, _ := strings.Cut(string(key), "\x00")
+			if query.To != "" && date > query.To {
+				break
+			}
+			var entry CatalogEntry
+			if err := json.Unmarshal(files.Get([]byte(destination)), &entry); err != nil {
+				continue
+			}
+			if !query.Deleted && entry.Deleted != nil {
+				continue
+			}
+			if query.Template != "" && entry.Template != query.Template {
+				continue
+			}
+			if query.Name != "" && !strings.Contains(entry.Filename, query.Name) {
+				continue
+			}
+			entries = append(entries, entry)
+			if query.Limit > 0 && len(entries) >= query.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// TemplateStats summarises the products of one file template.
+type TemplateStats struct {
+	Template     string `json:"template"`
+	Files        int    `json:"files"` // Still on disk
+	Bytes        int64  `json:"bytes"`
+	Deleted      int    `json:"deleted"`
+	DeletedBytes int64  `json:"deleted_bytes"`
+	FirstDate    string `json:"first_date"`
+	LastDate     string `json:"last_date"`
+	LastArrival  string `json:"last_arrival"`
+}
+
+// stats returns the statistics of every file template in the catalog.
+func (c *Catalog) stats() ([]TemplateStats, error) {
+	if c == nil {
+		return nil, fmt.Errorf("catalog is not available")
+	}
+	byTemplate := make(map[string]*TemplateStats)
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(filesBucket).ForEach(func(_, value []byte) error {
+			var entry CatalogEntry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return nil
+			}
+			stats, ok := byTemplate[entry.Template]
+			if !ok {
+				stats = &TemplateStats{Template: entry.Template, FirstDate: entry.Date, LastDate: entry.Date}
+				byTemplate[entry.Template] = stats
+			}
+			if entry.Deleted != nil {
+				stats.Deleted++
+				stats.DeletedBytes += entry.Size
+			} else {
+				stats.Files++
+				stats.Bytes += entry.Size
+			}
+			stats.FirstDate = min(stats.FirstDate, entry.Date)
+			stats.LastDate = max(stats.LastDate, entry.Date)
+			stats.LastArrival = max(stats.LastArrival, entry.Arrival.UTC().Format(time.RFC3339))
+			return nil
+		})
+	})
+
+	result := make([]TemplateStats, 0, len(byTemplate))
+	for _, stats := range byTemplate {
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Template < result[j].Template
+	})
+	return result, err
+}
+
+// templateName returns the pattern that identifies a file template.
+func templateName(template StructTemplate) string {
+	if template.Regex != "" {
+		return template.Regex
+	}
+	return template.FileTemplate
+}
+
+// catalogHandler serves GET /api/catalog. The parameters name, template,
+// from, to, deleted and limit select the entries, see CatalogQuery.
+func catalogHandler(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	query := CatalogQuery{
+		Name:     values.Get("name"),
+		Template: values.Get("template"),
+		From:     values.Get("from"),
+		To:       values.Get("to"),
+		Deleted:  values.Get("deleted") == "true",
+		Limit:    1000,
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		query.Limit = n
+	}
+
+	entries, err := catalog.search(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// catalogStatsHandler serves GET /api/catalog/stats.
+func catalogStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := catalog.stats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
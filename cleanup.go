@@ -39,19 +39,20 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
-	"math"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -61,41 +62,124 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// StructTemplate describes a kind of incoming file. A positional template uses
+// the glob FileTemplate with the date at StartDate in DateLayout. A regex
+// template uses Regex instead, with named groups year, month, day, doy, hour,
+// minute, satellite and channel. Destination is the layout of the directory
+// the file is moved to, relative to the basepath (default {yyyy}/{mm}/{dd}).
 type StructTemplate struct {
 	FileTemplate string `yaml:"filetemplate"`
 	StartDate    int    `yaml:"startdate"`
 	DateLayout   string `yaml:"datelayout"`
+	Regex        string `yaml:"regex"`
+	Destination  string `yaml:"destination"`
+	Collision    string `yaml:"collision"` // Policy when the destination file exists, see collision.go
+	// Expected seconds between files, and the gap in seconds after which the
+	// stream counts as stalled (default 3 times the cadence), see gaps.go.
+	Cadence int `yaml:"cadence"`
+	MaxGap  int `yaml:"maxgap"`
 }
 
 type StructDisks struct {
 	DiskName      string `yaml:"diskname"`
 	FreeDiskSpace int    `yaml:"freediskspace"`
+	Eviction      string `yaml:"eviction"` // Strategy to free space, see eviction.go
+	// Thresholds in bytes, GiB, inodes or percent, see thresholds.go. Cleanup
+	// starts below the minimum and frees space up to the target.
+	MinFree          string `yaml:"minfree"`
+	TargetFree       string `yaml:"targetfree"`
+	MinFreeInodes    string `yaml:"minfreeinodes"`
+	TargetFreeInodes string `yaml:"targetfreeinodes"`
+}
+
+// StructQuarantine configures where unmatched files are kept instead of being
+// deleted, and for how many days (0 keeps them until removed by hand).
+type StructQuarantine struct {
+	Directory     string `yaml:"directory"`
+	RetentionDays int    `yaml:"retentiondays"`
+}
+
+// StructBasePath is a directory where incoming files are received. Files are
+// filed below DestinationRoot, which defaults to the basepath itself and may
+// be on another filesystem.
+type StructBasePath struct {
+	Path            string            `yaml:"path"`
+	Quarantine      *StructQuarantine `yaml:"quarantine"`
+	DestinationRoot string            `yaml:"destinationroot"`
+	VerifyChecksum  bool              `yaml:"verifychecksum"` // Verify copies across filesystems
+	Retention       StructRetention   `yaml:"retention"`
+	Archive         *StructArchive    `yaml:"archive"`  // Archive day directories before deleting them
+	Weight          int               `yaml:"weight"`   // Share of the disk for the weighted eviction
+	Priority        int               `yaml:"priority"` // Lower priorities are evicted first
+}
+
+// destinationRoot returns the directory below which the files of the basepath
+// are filed.
+func (b StructBasePath) destinationRoot() string {
+	if b.DestinationRoot != "" {
+		return b.DestinationRoot
+	}
+	return b.Path
+}
+
+// UnmarshalYAML accepts a basepath either as a plain string or as a mapping
+// with per-basepath settings.
+func (b *StructBasePath) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		b.Path = value.Value
+		return nil
+	}
+	type plain StructBasePath
+	if err := value.Decode((*plain)(b)); err != nil {
+		return err
+	}
+	// Node.Decode does not check for unknown keys like the strict decoder of
+	// validateConfig, so a misspelled retention would go unnoticed.
+	return unknownFields(value, reflect.TypeOf(*b))
 }
 
 type YAMLConfig struct {
 	FileTemplates []StructTemplate `yaml:"filetemplates"`
-	BasePaths     []string         `yaml:"basepaths"`
+	BasePaths     []StructBasePath `yaml:"basepaths"`
 	Disks         []StructDisks    `yaml:"disks"`
 	PortNumber    string           `yaml:"portnumber"`
+	ListenAddress string           `yaml:"listenaddress"` // Interface address of the web server, all when empty
+	Catalog       string           `yaml:"catalog"`       // Catalog file of filed products, see catalog.go
+	AuditLog      StructAuditLog   `yaml:"auditlog"`
+	DryRun        bool             `yaml:"dryrun"`
+	Quarantine    StructQuarantine `yaml:"quarantine"`
+	ScanInterval  int              `yaml:"scaninterval"` // Seconds between sweeps of the basepaths
+	// A file is only moved after its size and modification time have not
+	// changed for SettleWindow seconds. Files ending in one of the
+	// ExcludeSuffixes are left alone.
+	SettleWindow    int      `yaml:"settlewindow"`
+	ExcludeSuffixes []string `yaml:"excludesuffixes"`
+	// Webhooks and mail of operational events, see notify.go
+	Notifications StructNotifications `yaml:"notifications"`
 }
 
 var yamlconfig YAMLConfig
 var regexPatterns []*regexp.Regexp
-var portnumber = "7000"
+var portnumber = "7000" // Default when portnumber is not configured
 
 // SystemMetrics represents CPU and Disk data for sending to the client
 type SystemMetrics struct {
-	CoreUsages  []float64 `json:"core_usages"` // Percentage for each core
-	DiskUsed    []float64 `json:"disks_used"`  // Percentage of disk space used
-	DiskFree    []float64 `json:"disks_free"`  // Percentage of disk space free
-	Timestamp   int64     `json:"timestamp"`   // Unix timestamp in milliseconds
-	DiskLabel   []string  `json:"disks_label"` // Label for disk
-	DiskTotal   []uint64  `json:"disks_total"` // Total disk space
-	AvailDirs   []string  `json:"avail_dirs"`
+	CoreUsages  []float64 `json:"core_usages"`  // Percentage for each core
+	DiskUsed    []float64 `json:"disks_used"`   // Percentage of disk space used
+	DiskFree    []float64 `json:"disks_free"`   // Percentage of disk space free
+	Timestamp   int64     `json:"timestamp"`    // Unix timestamp in milliseconds
+	DiskLabel   []string  `json:"disks_label"`  // Label for disk
+	DiskTotal   []uint64  `json:"disks_total"`  // Total disk space
 	MemoryUsed  float64   `json:"memory_used"`  // Percentage of memory used
 	MemoryFree  float64   `json:"memory_free"`  // Percentage of memory free
 	MemoryTotal uint64    `json:"memory_total"` // Total memory in MB
 
+	MoveErrors []MoveError   `json:"move_errors"` // Files and basepaths that could not be filed
+	DiskMap    []DiskMapping `json:"disk_map"`    // Basepaths cleaned for each disk
+	// Years, months and days below every destination root
+	DirTrees []BasePathTree `json:"dir_trees"`
+	// Product streams without a file for longer than their maximum gap
+	StreamAlerts []StreamAlert `json:"stream_alerts"`
 }
 
 // Global variables
@@ -103,18 +187,33 @@ var (
 	clients = make(map[*websocket.Conn]bool)
 	//broadcast = make(chan SystemMetrics)
 	mutex sync.Mutex
+	// moveMutex serialises the periodic sweep and the inotify watcher
+	moveMutex sync.Mutex
 )
 
-// Function for the 10-second event
-func eventMoveFiles(done chan bool) {
+// Function for the periodic sweep of the basepaths. With the inotify watcher
+// running this is only a fallback, so it runs less often as long as every
+// basepath is watched.
+func eventMoveFiles(done chan bool, watcher *basePathWatcher) {
 	for {
 		select {
 		case <-done:
 			return
 		default:
-			fmt.Println("Event 1: Executing every 10 seconds")
+			interval := 10 * time.Second
+			if watcher != nil {
+				watcher.syncWatches()
+				if watcher.watchingAll() {
+					interval = 60 * time.Second
+				}
+			}
+			if scanInterval := currentConfig().ScanInterval; scanInterval > 0 {
+				interval = time.Duration(scanInterval) * time.Second
+			}
+
+			fmt.Printf("Event 1: Executing every %v\n", interval)
 			moveFilesToDateSubdirs()
-			time.Sleep(10 * time.Second)
+			time.Sleep(interval)
 		}
 	}
 }
@@ -127,7 +226,9 @@ func eventDeleteOldDirs(done chan bool) {
 			return
 		default:
 			fmt.Println("Event 2: Executing every 30 minutes")
+			deleteExpiredDirectories()
 			deleteOldDirectories()
+			purgeQuarantine()
 			time.Sleep(30 * time.Minute)
 		}
 	}
@@ -157,119 +258,456 @@ func convertYYYYDDDToYYYYMMDD(yyyydoy string) (string, error) {
 	return date.Format("20060102"), nil
 }
 
+// errDateLayout is returned for a file template with an unknown DateLayout.
+var errDateLayout = errors.New("DateLayout is not YYYYMMDD or YYYYDDD")
+
+// errNotSettled is returned by moveFile for a file that may still be written.
+var errNotSettled = errors.New("file is still being written")
+
+// MoveError describes a file, or a whole basepath when File is empty, that
+// could not be filed.
+type MoveError struct {
+	Timestamp int64  `json:"timestamp"` // Unix timestamp in milliseconds
+	BasePath  string `json:"basepath"`
+	File      string `json:"file"`
+	Error     string `json:"error"`
+}
+
+// maxMoveErrors limits the number of errors kept for the web interface.
+const maxMoveErrors = 100
+
+var (
+	moveErrors      []MoveError
+	moveErrorsMutex sync.Mutex
+)
+
+func newMoveError(basepath, file string, err error) MoveError {
+	return MoveError{
+		Timestamp: time.Now().UnixMilli(),
+		BasePath:  basepath,
+		File:      file,
+		Error:     err.Error(),
+	}
+}
+
+// setMoveErrors replaces the error report with the errors of the last sweep.
+func setMoveErrors(errs []MoveError) {
+	for _, moveError := range errs {
+		addCounter("cleanup_move_errors_total", 1, "basepath", moveError.BasePath)
+	}
+	moveErrorsMutex.Lock()
+	defer moveErrorsMutex.Unlock()
+	if len(errs) > maxMoveErrors {
+		errs = errs[len(errs)-maxMoveErrors:]
+	}
+	moveErrors = errs
+}
+
+// addMoveError adds an error found outside a sweep to the error report.
+func addMoveError(moveError MoveError) {
+	addCounter("cleanup_move_errors_total", 1, "basepath", moveError.BasePath)
+	moveErrorsMutex.Lock()
+	defer moveErrorsMutex.Unlock()
+	moveErrors = append(moveErrors, moveError)
+	if len(moveErrors) > maxMoveErrors {
+		moveErrors = moveErrors[len(moveErrors)-maxMoveErrors:]
+	}
+}
+
+// getMoveErrors returns a copy of the error report.
+func getMoveErrors() []MoveError {
+	moveErrorsMutex.Lock()
+	defer moveErrorsMutex.Unlock()
+	return append([]MoveError{}, moveErrors...)
+}
+
+// fileObservation is the size and modification time of a file when it was
+// last seen in a basepath.
+type fileObservation struct {
+	size    int64
+	modTime time.Time
+}
+
+// observedFiles holds the last observation of every file in the basepaths
+// that has not been filed yet. It is guarded by moveMutex.
+var observedFiles = make(map[string]fileObservation)
+
+// isSettled reports whether a file has not changed for the settle window: its
+// modification time is at least that old and its size and modification time
+// match the previous observation, if any.
+func isSettled(fullPath string, info os.FileInfo, window time.Duration) bool {
+	current := fileObservation{size: info.Size(), modTime: info.ModTime()}
+	previous, seen := observedFiles[fullPath]
+	observedFiles[fullPath] = current
+
+	if window <= 0 {
+		return true
+	}
+	if seen && previous != current {
+		return false
+	}
+	return time.Since(info.ModTime()) >= window
+}
+
+// isExcluded reports whether a filename ends in one of the excluded suffixes
+// used for files that are still being received, like .part or .tmp.
+func (cfg *Config) isExcluded(filename string) bool {
+	for _, suffix := range cfg.ExcludeSuffixes {
+		if suffix != "" && strings.HasSuffix(filename, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsedName holds the values extracted from a filename by the first file
+// template that matches it. Month, Day and Doy are always filled in, whatever
+// form the date has in the filename.
+type ParsedName struct {
+	Template  int    // Index in Config.FileTemplates
+	Date      string // Date as found in the filename, YYYYMMDD or YYYYDDD
+	Year      string
+	Month     string
+	Day       string
+	Doy       string
+	Hour      string
+	Minute    string
+	Satellite string
+	Channel   string
+}
+
+// parseFilename matches filename against the file templates, first match wins.
+// It returns nil and the reason when the file is not matched, and an error when
+// a template matches but the date in the filename is not valid.
+func (cfg *Config) parseFilename(filename string) (*ParsedName, string, error) {
+	for i, re := range cfg.Patterns {
+		if cfg.FileTemplates[i].Regex != "" {
+			match := re.FindStringSubmatch(filename)
+			if match == nil {
+				continue
+			}
+			parsed := &ParsedName{Template: i}
+			for j, name := range re.SubexpNames() {
+				switch name {
+				case "year":
+					parsed.Year = match[j]
+				case "month":
+					parsed.Month = match[j]
+				case "day":
+					parsed.Day = match[j]
+				case "doy":
+					parsed.Doy = match[j]
+				case "hour":
+					parsed.Hour = match[j]
+				case "minute":
+					parsed.Minute = match[j]
+				case "satellite":
+					parsed.Satellite = match[j]
+				case "channel":
+					parsed.Channel = match[j]
+				}
+			}
+			if parsed.Doy != "" && parsed.Month == "" {
+				if err := parsed.setDate(parsed.Year+parsed.Doy, "YYYYDDD"); err != nil {
+					return nil, "", err
+				}
+			} else if err := parsed.setDate(parsed.Year+parsed.Month+parsed.Day, "YYYYMMDD"); err != nil {
+				return nil, "", err
+			}
+			return parsed, "", nil
+		}
+
+		if !re.MatchString(filename) {
+			continue
+		}
+		start := cfg.FileTemplates[i].StartDate
+		datelayout := cfg.FileTemplates[i].DateLayout
+		var length int
+		if datelayout == "YYYYMMDD" {
+			length = 8
+		} else if datelayout == "YYYYDDD" {
+			length = 7
+		} else {
+			return nil, "", fmt.Errorf("template %d: %w", i+1, errDateLayout)
+		}
+		// Check if filename is long enough to extract the date substring
+		if start+length > len(filename) {
+			fmt.Printf("Warning: Filename %s too short for date at position %d\n", filename, start)
+			return nil, fmt.Sprintf("filename too short for date at position %d of template %s", start, cfg.FileTemplates[i].FileTemplate), nil
+		}
+		parsed := &ParsedName{Template: i}
+		if err := parsed.setDate(filename[start:start+length], datelayout); err != nil {
+			return nil, "", err
+		}
+		return parsed, "", nil
+	}
+	return nil, "no file template matched", nil
+}
+
+// setDate validates a YYYYMMDD or YYYYDDD date string and fills in the date
+// fields of p.
+func (p *ParsedName) setDate(dateStr, datelayout string) error {
+	p.Date = dateStr
+	if datelayout == "YYYYDDD" {
+		if len(dateStr) != 7 {
+			return fmt.Errorf("invalid date %s", dateStr)
+		}
+		convertedDate, err := convertYYYYDDDToYYYYMMDD(dateStr)
+		if err != nil {
+			return fmt.Errorf("failed to convert date %s: %v", dateStr, err)
+		}
+		dateStr = convertedDate
+	} else if len(dateStr) != 8 || !isValidDate(dateStr) {
+		return fmt.Errorf("invalid date %s", dateStr)
+	}
+	p.Year = dateStr[0:4]
+	p.Month = dateStr[4:6]
+	p.Day = dateStr[6:8]
+
+	date, err := time.Parse("20060102", dateStr)
+	if err != nil {
+		return fmt.Errorf("invalid date %s", dateStr)
+	}
+	p.Doy = fmt.Sprintf("%03d", date.YearDay())
+	return nil
+}
+
 func moveFilesToDateSubdirs() error {
 
 	fmt.Printf("Moving files to date subdirectories\n")
 
-	if len(regexPatterns) == 0 {
+	cfg := currentConfig()
+	if len(cfg.Patterns) == 0 {
 		return fmt.Errorf("regexPatterns is empty")
 	}
-	// Compile regex patterns for each filetemplate
-	// Process each base path
-	for _, basepath := range yamlconfig.BasePaths {
-		entries, err := os.ReadDir(basepath)
+
+	moveMutex.Lock()
+	defer moveMutex.Unlock()
+
+	var report dryRunReport
+	if cfg.DryRun {
+		defer report.print("moveFilesToDateSubdirs")
+	}
+	seen := make(map[string]bool)
+	var moveErrors []MoveError
+	// Process each base path. A failure only skips the file or basepath
+	// concerned; all failures are collected in the error report.
+	for _, bp := range cfg.BasePaths {
+		entries, err := os.ReadDir(bp.Path)
 		if err != nil {
-			return fmt.Errorf("failed to read directory %s: %v", basepath, err)
+			moveErrors = append(moveErrors, newMoveError(bp.Path, "", fmt.Errorf("failed to read directory %s: %v", bp.Path, err)))
+			notifier.notify(EventBasePathUnreadable, bp.Path, "Basepath %s cannot be read: %v", bp.Path, err)
+			continue
 		}
+		notifier.resolve(EventBasePathUnreadable, bp.Path)
 
 		for _, entry := range entries {
 			// Process only files (skip directories)
 			if entry.IsDir() {
 				continue
 			}
-
-			filename := entry.Name()
-			matched := false
-			var dateStr string
-			var datelayout string
-
-			// Check each template for a match
-			for i, re := range regexPatterns {
-				if re.MatchString(filename) {
-					start := yamlconfig.FileTemplates[i].StartDate
-					// Check if filename is long enough to extract the date substring
-					if yamlconfig.FileTemplates[i].DateLayout == "YYYYMMDD" {
-						datelayout = "YYYYMMDD"
-						if start+8 > len(filename) {
-							fmt.Printf("Warning: Filename %s too short for date at position %d\n", filename, start)
-							matched = false
-							break
-						}
-						dateStr = filename[start : start+8]
-						matched = true
-					} else if yamlconfig.FileTemplates[i].DateLayout == "YYYYDDD" {
-						datelayout = "YYYYDDD"
-						if start+7 > len(filename) {
-							fmt.Printf("Warning: Filename %s too short for date at position %d\n", filename, start)
-							matched = false
-							break
-						}
-						dateStr = filename[start : start+7]
-						matched = true
-					} else {
-						return fmt.Errorf("DateLayout is not YYYYMMDD or YYYYDDD")
-					}
-					break
-				}
+			seen[filepath.Join(bp.Path, entry.Name())] = true
+			if err := moveFile(cfg, bp, entry.Name(), &report); err != nil && !errors.Is(err, errNotSettled) {
+				moveErrors = append(moveErrors, newMoveError(bp.Path, entry.Name(), err))
 			}
+		}
+	}
 
-			fullPath := filepath.Join(basepath, filename)
-			// If no template matches, delete the file
-			if !matched {
-				if err := os.Remove(fullPath); err != nil {
-					return fmt.Errorf("failed to delete unmatched file %s: %v", fullPath, err)
-				}
-				fmt.Printf("Deleted unmatched file: %s\n", fullPath)
-				continue
-			}
+	// Forget files that disappeared without being filed by us.
+	for fullPath := range observedFiles {
+		if !seen[fullPath] {
+			delete(observedFiles, fullPath)
+		}
+	}
 
-			// Validate the date substring (YYYYMMDD)
-			var year, month, day string
-			if datelayout == "YYYYMMDD" {
-				if len(dateStr) != 8 || !isValidDate(dateStr) {
-					fmt.Printf("Warning: Invalid date %s in filename %s\n", dateStr, filename)
-					continue // Skip the file if date is invalid
-				}
-				year = dateStr[0:4]
-				month = dateStr[4:6]
-				day = dateStr[6:8]
+	setMoveErrors(moveErrors)
+	if len(moveErrors) > 0 {
+		fmt.Printf("Move error report: %d error(s)\n", len(moveErrors))
+		errs := make([]error, 0, len(moveErrors))
+		for _, moveError := range moveErrors {
+			fmt.Printf("  %s %s: %s\n", moveError.BasePath, moveError.File, moveError.Error)
+			errs = append(errs, errors.New(moveError.Error))
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
 
-			} else {
-				if len(dateStr) != 7 {
-					fmt.Printf("Warning: Invalid date %s in filename %s\n", dateStr, filename)
-					continue // Skip the file if date is invalid
-				}
-				convertedDate, err := convertYYYYDDDToYYYYMMDD(dateStr)
-				if err != nil {
-					fmt.Printf("Warning: Failed to convert date %s: %v\n", dateStr, err)
-					continue
-				}
-				year = convertedDate[0:4]
-				month = convertedDate[4:6]
-				day = convertedDate[6:8]
-			}
+// moveFile files a single file of a basepath: it is moved to its destination
+// directory when a template matches, and quarantined or deleted otherwise.
+// Files that may still be written are left alone and errNotSettled is
+// returned. The caller must hold moveMutex.
+func moveFile(cfg *Config, bp StructBasePath, filename string, report *dryRunReport) error {
+	basepath := bp.Path
+	fullPath := filepath.Join(basepath, filename)
+
+	if cfg.isExcluded(filename) {
+		return nil
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			delete(observedFiles, fullPath)
+			return nil // Already filed
+		}
+		return fmt.Errorf("failed to stat %s: %v", fullPath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	if !isSettled(fullPath, info, time.Duration(cfg.SettleWindow)*time.Second) {
+		return errNotSettled
+	}
+	if !cfg.DryRun {
+		delete(observedFiles, fullPath)
+	}
 
-			// Construct the new subdirectory path: basepath/YYYY/MM/DD
-			newSubdir := filepath.Join(basepath, year, month, day)
-			newPath := filepath.Join(newSubdir, filename)
+	parsed, reason, err := cfg.parseFilename(filename)
+	if errors.Is(err, errDateLayout) {
+		return err
+	}
+	if err != nil {
+		// The file is left in place and shows up in the move error report.
+		return fmt.Errorf("%v in filename %s, left in place", err, filename)
+	}
 
-			// Create the destination directory if it does not exist
-			if err := os.MkdirAll(newSubdir, 0755); err != nil {
-				return fmt.Errorf("failed to create directory %s: %v", newSubdir, err)
+	// If no template matches, quarantine or delete the file
+	if parsed == nil {
+		quarantine := cfg.quarantineFor(bp)
+		if quarantine.Directory != "" {
+			if cfg.DryRun {
+				report.add("quarantine", fullPath+" -> "+quarantine.Directory+" ("+reason+")")
+				return nil
 			}
-
-			// Move the file to the new destination
-			if err := os.Rename(fullPath, newPath); err != nil {
-				return fmt.Errorf("failed to move %s to %s: %v", fullPath, newPath, err)
+			newPath, err := quarantineFile(quarantine.Directory, basepath, filename, reason)
+			auditLog.record(AuditEntry{Action: AuditQuarantine, Source: fullPath, Destination: newPath,
+				Size: info.Size(), Rule: "unmatched: " + reason}, err)
+			if err != nil {
+				return err
 			}
-			fmt.Printf("Moved %s to %s\n", filename, newSubdir)
+			addCounter("cleanup_unmatched_quarantined_total", 1, "basepath", basepath)
+			notifier.noteUnmatched(basepath)
+			fmt.Printf("Quarantined unmatched file: %s (%s)\n", fullPath, reason)
+			return nil
+		}
+		if cfg.DryRun {
+			report.add("delete", fullPath)
+			return nil
+		}
+		err := os.Remove(fullPath)
+		auditLog.record(AuditEntry{Action: AuditDelete, Source: fullPath, Size: info.Size(), Rule: "unmatched: " + reason}, err)
+		if err != nil {
+			return fmt.Errorf("failed to delete unmatched file %s: %v", fullPath, err)
+		}
+		addCounter("cleanup_unmatched_deleted_total", 1, "basepath", basepath)
+		notifier.noteUnmatched(basepath)
+		fmt.Printf("Deleted unmatched file: %s\n", fullPath)
+		return nil
+	}
 
+	// Construct the new subdirectory path, by default basepath/YYYY/MM/DD
+	destination, err := resolveDestination(destinationLayout(cfg.FileTemplates[parsed.Template]), parsed)
+	if err != nil {
+		return fmt.Errorf("failed to resolve the destination of %s: %v", filename, err)
+	}
+	newSubdir := filepath.Join(bp.destinationRoot(), destination)
+	newPath, err := resolveCollision(cfg.FileTemplates[parsed.Template].Collision, fullPath, filepath.Join(newSubdir, filename))
+	if err != nil {
+		return err
+	}
+
+	// The file already exists at the destination and the policy keeps that one
+	if newPath == "" {
+		if cfg.DryRun {
+			report.add("delete", fullPath+" (duplicate)")
+			return nil
+		}
+		err := os.Remove(fullPath)
+		auditLog.record(AuditEntry{Action: AuditDelete, Source: fullPath, Destination: filepath.Join(newSubdir, filename),
+			Size: info.Size(), Rule: "duplicate, collision " + cfg.FileTemplates[parsed.Template].Collision}, err)
+		if err != nil {
+			return fmt.Errorf("failed to delete duplicate file %s: %v", fullPath, err)
 		}
+		fmt.Printf("Deleted duplicate file: %s\n", fullPath)
+		return nil
+	}
+
+	// The collision policy decided to overwrite the file at the destination
+	replaced, err := os.Lstat(newPath)
+	overwrite := err == nil
+
+	if cfg.DryRun {
+		if overwrite {
+			report.add("replace", fmt.Sprintf("%s (%d bytes)", newPath, replaced.Size()))
+		}
+		report.add("move", fullPath+" -> "+newPath)
+		return nil
+	}
+
+	// Create the destination directory if it does not exist
+	if err := os.MkdirAll(newSubdir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", newSubdir, err)
 	}
 
+	// Move the file to the new destination, copying it across filesystems
+	err = renameFile(fullPath, newPath, bp.VerifyChecksum)
+	if overwrite {
+		policy := cfg.FileTemplates[parsed.Template].Collision
+		if policy == "" {
+			policy = CollisionOverwrite
+		}
+		auditLog.record(AuditEntry{Action: AuditReplace, Source: newPath, Size: replaced.Size(),
+			Rule: "collision " + policy + ", replaced by " + fullPath}, err)
+	}
+	auditLog.record(AuditEntry{Action: AuditMove, Source: fullPath, Destination: newPath, Size: info.Size(),
+		Rule: templateName(cfg.FileTemplates[parsed.Template])}, err)
+	if err != nil {
+		return fmt.Errorf("failed to move %s to %s: %v", fullPath, newPath, err)
+	}
+	fmt.Printf("Moved %s to %s\n", filename, newPath)
+	template := templateName(cfg.FileTemplates[parsed.Template])
+	addCounter("cleanup_files_moved_total", 1, "basepath", basepath, "template", template)
+	addCounter("cleanup_bytes_moved_total", float64(info.Size()), "basepath", basepath, "template", template)
+	setGauge("cleanup_last_move_timestamp_seconds", float64(time.Now().Unix()), "basepath", basepath, "template", template)
+	recordArrival(template, time.Now())
+
+	err = catalog.recordFiled(CatalogEntry{
+		Filename:    filename,
+		Template:    template,
+		Date:        parsed.Year + parsed.Month + parsed.Day,
+		Size:        info.Size(),
+		Arrival:     time.Now(),
+		BasePath:    basepath,
+		Destination: newPath,
+	})
+	if err != nil {
+		fmt.Printf("Warning: failed to add %s to the catalog: %v\n", newPath, err)
+	}
 	return nil
 }
 
+// compileNamedTemplate compiles the regular expression of a regex template and
+// checks that its named groups are enough to build a date.
+func compileNamedTemplate(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			groups[name] = true
+		}
+	}
+	if !groups["year"] {
+		return nil, fmt.Errorf("missing named group year")
+	}
+	if !groups["doy"] && (!groups["month"] || !groups["day"]) {
+		return nil, fmt.Errorf("missing named groups month and day, or doy")
+	}
+	return re, nil
+}
+
 // Helper function to validate YYYYMMDD date string
 func isValidDate(dateStr string) bool {
 	if len(dateStr) != 8 {
@@ -288,109 +726,131 @@ func isValidDate(dateStr string) bool {
 	return m >= 1 && m <= 12 && d >= 1 && d <= 31 // Simplified; ignores month-specific days
 }
 
-// DirectoryInfo holds information about a directory
+// DirectoryInfo holds information about a day directory
 type DirectoryInfo struct {
-	Path    string
-	ModTime int64
+	Path     string
+	BasePath string
+	ModTime  int64  // Date of the directory as YYYYMMDD
+	Prefix   string // Non-date components of the layout, e.g. the satellite
 }
 
 func deleteOldDirectories() error {
 	fmt.Println("Deleting old directories")
-	// Collect all DD directories (format YYYY/MM/DD) from each base path.
+
+	cfg := currentConfig()
+
+	// Collect all day directories (by default YYYY/MM/DD) from each base path.
 	var directories []DirectoryInfo
-	var requiredfreediskspace int
-	requiredfreediskspace = 20
 
-	for _, thedisk := range yamlconfig.Disks {
+	var report dryRunReport
+	if cfg.DryRun {
+		defer report.print("deleteOldDirectories")
+	}
+
+	for _, thedisk := range cfg.Disks {
 
-		requiredfreediskspace = thedisk.FreeDiskSpace
-		freeSpace, err := getFreeSpacePercentage(thedisk.DiskName)
+		limits, err := limitsFor(thedisk)
+		if err != nil {
+			return fmt.Errorf("disk %s: %v", thedisk.DiskName, err)
+		}
+		state, err := statDisk(thedisk.DiskName)
 		directories = []DirectoryInfo{}
 
-		fmt.Printf("Disk: %s free space %f required:%d \n", thedisk.DiskName, freeSpace, requiredfreediskspace)
+		fmt.Printf("Disk: %s %s required: %s\n", thedisk.DiskName, state, limits)
 		fmt.Print("=======================================================\n")
 		if err != nil {
 			return fmt.Errorf("error getting free space: %v", err)
 		}
-		if int(math.Round(freeSpace)) >= requiredfreediskspace {
+		if !limits.needsCleanup(state) {
+			notifier.resolve(EventDiskFull, thedisk.DiskName)
 			continue
 		}
 
+		// In dry-run mode nothing is removed, so keep track of the directories
+		// that would have been deleted and simulate the space they free.
+		removed := make(map[string]bool)
+		now := time.Now()
+		eviction := newEvictionState(cfg, thedisk.Eviction)
+
 		for {
 
 			directories = []DirectoryInfo{}
 
-			for _, basePath := range yamlconfig.BasePaths {
-				if !strings.Contains(basePath, thedisk.DiskName) {
-					continue
-				}
-
-				// Construct a glob pattern for candidate DD directories.
-				pattern := filepath.Join(basePath, "????", "??", "??")
-				matches, err := filepath.Glob(pattern)
+			// Only basepaths on the same filesystem free space on this disk.
+			for _, basePath := range cfg.diskBasePaths(thedisk.DiskName) {
+				// Collect the candidate day directories of every layout in use,
+				// except the ones within the minimum retention.
+				retention := cfg.retentionFor(basePath)
+				matches, err := cfg.dayDirectories(basePath)
 				if err != nil {
-					return fmt.Errorf("failed to glob pattern %s: %v", pattern, err)
+					return err
 				}
-
-				// Process the matched paths.
 				for _, match := range matches {
-					info, err := os.Stat(match)
-					if err != nil || !info.IsDir() {
+					if removed[match.Path] || retention.protected(match.ModTime, now) {
 						continue
 					}
-					// Get the relative path from basePath; expected to be "YYYY/MM/DD"
-					relPath, err := filepath.Rel(basePath, match)
-					if err != nil {
-						continue
-					}
-					parts := strings.Split(relPath, string(os.PathSeparator))
-					if len(parts) != 3 {
-						continue
-					}
-					year, month, day := parts[0], parts[1], parts[2]
-					if len(year) != 4 || len(month) != 2 || len(day) != 2 {
-						continue
-					}
-					// Build a date key, e.g. 20220225.
-					dateKey := year + month + day
-					dateKeyInt, _ := strconv.ParseInt(dateKey, 10, 64)
-					directories = append(directories, DirectoryInfo{
-						Path:    match,
-						ModTime: dateKeyInt,
-					})
+					directories = append(directories, match)
 				}
 
-				}
+			}
 
 			// Check if there are any directories to delete
 			if len(directories) == 0 {
-				fmt.Printf("No more directories to delete for disk %s, but free space is still below required (%d%%)\n",
-					thedisk.DiskName, requiredfreediskspace)
+				fmt.Printf("No more directories outside the minimum retention to delete for disk %s, but %s is still below required (%s)\n",
+					thedisk.DiskName, state, limits)
+				notifier.notify(EventDiskFull, thedisk.DiskName, "No more directories outside the minimum retention to delete for disk %s, but %s is still below required (%s)",
+					thedisk.DiskName, state, limits)
 				break
 			}
 
-			// Sort the directories by their date extracted from the relative path.
-			sort.Slice(directories, func(i, j int) bool {
-				return directories[i].ModTime < directories[j].ModTime
-			})
-
-			// Delete the oldest directory (first in the sorted slice).
-			oldestDir := directories[0]
-			fmt.Printf("Deleting directory: %s\n", oldestDir.Path)
-			if err := os.RemoveAll(oldestDir.Path); err != nil {
-				return fmt.Errorf("error deleting directory %s: %v", oldestDir.Path, err)
+			// Pick the directory to delete with the eviction strategy of the disk.
+			oldestDir, err := eviction.pick(directories)
+			if err != nil {
+				return err
+			}
+			usage, err := eviction.usage(oldestDir.Path)
+			if err != nil {
+				return err
 			}
+			size := usage.Bytes
+			if cfg.DryRun {
+				eviction.deleted(oldestDir, size)
+				if archiveDir := cfg.archiveFor(oldestDir.BasePath); archiveDir != "" {
+					report.add("archive", fmt.Sprintf("%s to %s", oldestDir.Path, archiveDir))
+				}
+				report.add("delete", fmt.Sprintf("%s (%d bytes)", oldestDir.Path, size))
+				removed[oldestDir.Path] = true
+				for _, dir := range simulateCleanUpEmptyAncestors(oldestDir.Path, oldestDir.BasePath, removed) {
+					report.add("prune", dir)
+				}
+				state.FreeBytes += float64(usage.Bytes)
+				state.FreeInodes += float64(usage.Inodes)
+			} else {
+				fmt.Printf("Deleting directory: %s\n", oldestDir.Path)
+				rule := fmt.Sprintf("disk %s below %s, eviction %s", thedisk.DiskName, limits, eviction.strategy)
+				if err := removeDay(cfg, oldestDir, size, rule, state.String()); err != nil {
+					// Skip the day, so that one day that cannot be archived or
+					// deleted does not stop the cleaning of the disk.
+					fmt.Printf("Error: %v, skipping it\n", err)
+					notifier.notify(EventDeleteFailed, oldestDir.Path, "Day directory not deleted for disk %s: %v", thedisk.DiskName, err)
+					removed[oldestDir.Path] = true
+					continue
+				}
+				notifier.resolve(EventDeleteFailed, oldestDir.Path)
+				eviction.deleted(oldestDir, size)
+				recordReclaim(oldestDir.BasePath, size, false)
 
-			// After deleting the DD directory, attempt to clean up empty parent directories.
-			cleanUpEmptyAncestors(oldestDir.Path)
+				// After deleting the day directory, attempt to clean up empty parent directories.
+				cleanUpEmptyAncestors(oldestDir.Path, oldestDir.BasePath)
 
-			// Check if we've reached the required free space
-			freeSpace, err = getFreeSpacePercentage(thedisk.DiskName)
-			if err != nil {
-				return fmt.Errorf("error getting free space: %v", err)
+				// Check if we've reached the required free space
+				state, err = statDisk(thedisk.DiskName)
+				if err != nil {
+					return fmt.Errorf("error getting free space: %v", err)
+				}
 			}
-			if int(math.Round(freeSpace)) >= requiredfreediskspace {
-				fmt.Printf("Reached required free space (%.2f%%) for disk %s\n", freeSpace, thedisk.DiskName)
+			if limits.reached(state) {
+				fmt.Printf("Reached required %s for disk %s\n", state, thedisk.DiskName)
 				break
 			}
 		}
@@ -402,10 +862,14 @@ func deleteOldDirectories() error {
 
 // cleanUpEmptyAncestors deletes empty parent directories
 // (e.g. the MM and YYYY directories) up to the corresponding BasePath.
-func cleanUpEmptyAncestors(deletedPath string) {
+func cleanUpEmptyAncestors(deletedPath, basePath string) {
 	// Walk upward from the deleted directory.
 	dir := filepath.Dir(deletedPath)
 	for {
+		// Never delete the base path itself.
+		if dir == filepath.Clean(basePath) {
+			break
+		}
 		// List entries in the current directory.
 		entries, err := os.ReadDir(dir)
 		if err != nil {
@@ -429,16 +893,92 @@ func cleanUpEmptyAncestors(deletedPath string) {
 	}
 }
 
-// getFreeSpacePercentage returns the percentage of free disk space.
-func getFreeSpacePercentage(diskdir string) (float64, error) {
-	var stat syscall.Statfs_t
-	err := syscall.Statfs(diskdir, &stat)
-	if err != nil {
-		return 0, err
+// simulateCleanUpEmptyAncestors mirrors cleanUpEmptyAncestors for a dry run.
+// A directory counts as empty when every entry in it is marked as removed.
+// The directories that would be pruned are added to removed and returned.
+func simulateCleanUpEmptyAncestors(deletedPath, basePath string, removed map[string]bool) []string {
+	var pruned []string
+	dir := filepath.Dir(deletedPath)
+	for {
+		if dir == filepath.Clean(basePath) {
+			break
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			break
+		}
+		empty := true
+		for _, entry := range entries {
+			if !removed[filepath.Join(dir, entry.Name())] {
+				empty = false
+				break
+			}
+		}
+		if !empty {
+			break
+		}
+		removed[dir] = true
+		pruned = append(pruned, dir)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return pruned
+}
+
+// dirSize returns the total size in bytes of the files below dir.
+func dirSize(dir string) (int64, error) {
+	usage, err := dirUsage(dir)
+	return usage.Bytes, err
+}
+
+// DirUsage is the space, the number of inodes and the number of files used by
+// a directory tree.
+type DirUsage struct {
+	Bytes  int64
+	Inodes int64
+	Files  int64
+}
+
+// dirUsage returns the total size of the files below dir, the number of
+// entries, including dir itself, and the number of files.
+func dirUsage(dir string) (DirUsage, error) {
+	var usage DirUsage
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		usage.Inodes++
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage.Bytes += info.Size()
+		usage.Files++
+		return nil
+	})
+	return usage, err
+}
+
+// dryRunReport collects the actions a dry-run pass would have performed.
+type dryRunReport struct {
+	actions []string
+}
+
+func (r *dryRunReport) add(action, target string) {
+	r.actions = append(r.actions, fmt.Sprintf("would %s %s", action, target))
+}
+
+func (r *dryRunReport) print(caller string) {
+	fmt.Printf("Dry-run report %s: %d action(s)\n", caller, len(r.actions))
+	for _, action := range r.actions {
+		fmt.Printf("  %s\n", action)
 	}
-	totalSpace := float64(stat.Blocks) * float64(stat.Bsize)
-	freeSpace := float64(stat.Bavail) * float64(stat.Bsize)
-	return (freeSpace / totalSpace) * 100, nil
 }
 
 // isNumeric checks if a string is purely numeric
@@ -453,46 +993,59 @@ func isNumeric(s string) bool {
 
 func main() {
 
-	data, err := os.ReadFile("directories.yaml")
-	if err != nil {
-		log.Fatalf("Error reading YAML file: %v", err)
-		return
+	dryRun := flag.Bool("dryrun", false, "report what would be moved, deleted or pruned without touching the filesystem")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n  %s [-dryrun]\n  %s validate [file]\n  %s test-templates [file with filenames]\n  %s restore bundle.tar.gz [root]\n  %s test-notify\n",
+			os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	// Parse the YAML content
+	forceDryRun = *dryRun
 
-	err = yaml.Unmarshal(data, &yamlconfig)
+	// Subcommands
+	switch flag.Arg(0) {
+	case "":
+	case "validate":
+		path := configPath
+		if flag.NArg() > 1 {
+			path = flag.Arg(1)
+		}
+		os.Exit(runValidate(path))
+	case "test-templates":
+		os.Exit(runTestTemplates(flag.Arg(1)))
+	case "restore":
+		os.Exit(runRestore(flag.Arg(1), flag.Arg(2)))
+	case "test-notify":
+		os.Exit(runTestNotify())
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config, patterns, err := loadConfig(configPath)
 	if err != nil {
-		log.Fatalf("Error parsing YAML: %v", err)
+		log.Fatalf("Error: %v", err)
 		return
 	}
+	yamlconfig = config
+	regexPatterns = patterns
+	notifier.configure(yamlconfig.Notifications, yamlconfig.DryRun)
 
 	// Print the parsed content
-	fmt.Println("File Templates:")
-	for i, template := range yamlconfig.FileTemplates {
-		fmt.Printf("  %d: %s %d %s\n", i+1, template.FileTemplate, template.StartDate, template.DateLayout)
-	}
-
-	// Compile regex patterns for each filetemplate
-	regexPatterns = make([]*regexp.Regexp, 0, len(yamlconfig.FileTemplates))
-	for _, template := range yamlconfig.FileTemplates {
-
-		// Convert glob-like patterns to regex (replace "*" with ".*")
-		escaped := regexp.QuoteMeta(template.FileTemplate)
-		escaped = strings.ReplaceAll(escaped, `\.`, `.`)
-		pattern := "^" + strings.ReplaceAll(escaped, `\*`, `.*`) + "$"
-		re, err := regexp.Compile(pattern)
-		if err != nil {
-			log.Fatalf("Error: invalid pattern %s: %v\n", template.FileTemplate, err)
-			return
-		}
-		regexPatterns = append(regexPatterns, re)
+	printConfig(currentConfig())
 
+	catalog, err = openCatalog(catalogPath(yamlconfig))
+	if err != nil {
+		fmt.Printf("Error: %v, filed products are not recorded\n", err)
 	}
+	defer catalog.Close()
 
-	// for i := range regexPatterns {
-	// 	fmt.Printf("%s\n", regexPatterns[i].String())
-	// }
+	auditLog, err = openAuditLog(yamlconfig.AuditLog)
+	if err != nil {
+		fmt.Printf("Error: %v, filesystem actions are not audited\n", err)
+	}
+	defer auditLog.Close()
 
 	ips, err := GetLocalIPs()
 	if err != nil {
@@ -505,6 +1058,11 @@ func main() {
 		fmt.Printf("Error moveFilesToDateSubdirs: %v\n", err)
 	}
 
+	err = deleteExpiredDirectories()
+	if err != nil {
+		fmt.Printf("Error deleteExpiredDirectories: %v\n", err)
+	}
+
 	err = deleteOldDirectories()
 	if err != nil {
 		fmt.Printf("Error DeleteOldDirectories: %v\n", err)
@@ -515,9 +1073,25 @@ func main() {
 	// Channel to signal goroutines to stop
 	done := make(chan bool)
 
+	// Watch the basepaths for new files. The periodic sweep stays as a
+	// fallback, every 10 seconds when the watcher is not available.
+	watcher, err := newBasePathWatcher()
+	if err != nil {
+		fmt.Printf("Error starting inotify watcher, polling instead: %v\n", err)
+	} else {
+		go watcher.run(done)
+	}
+
+	// Reload the configuration when the file changes or on SIGHUP
+	go watchConfigFile(watcher)
+	go handleReloadSignals(watcher)
+
 	// Start goroutines for each event
 	go eventDeleteOldDirs(done)
-	go eventMoveFiles(done)
+	go eventMoveFiles(done, watcher)
+	go eventStatusReport(done)
+	go eventCheckStreams(done)
+	go notifier.run(done)
 
 	//	select {}
 
@@ -536,8 +1110,25 @@ func main() {
 	// Register /disks endpoint to list available hard disks
 	http.HandleFunc("/disks", diskListHandler)
 
-	log.Println("Server starting on :" + portnumber + "...")
-	log.Fatal(http.ListenAndServe(":"+portnumber, nil))
+	// Search the catalog of filed products
+	http.HandleFunc("/api/catalog", catalogHandler)
+	http.HandleFunc("/api/catalog/stats", catalogStatsHandler)
+
+	// Prometheus metrics
+	http.HandleFunc("/metrics", metricsHandler)
+
+	// Search the audit log
+	http.HandleFunc("/api/audit", auditHandler)
+
+	// Reload directories.yaml without restarting
+	http.HandleFunc("/api/reload", reloadHandler(watcher))
+
+	// Versioned REST API, see api.go
+	registerAPI()
+
+	addr := listenAddress(currentConfig().YAMLConfig)
+	log.Println("Server starting on " + addr + "...")
+	log.Fatal(http.ListenAndServe(addr, nil))
 
 }
 
@@ -551,7 +1142,8 @@ func collectMetrics() {
 	var timestamps []int64                 // Shared timestamps for all cores
 
 	counter := 60
-	var availdirs []string
+	var dirtrees []BasePathTree
+	var diskmap []DiskMapping
 
 	for range ticker.C {
 		counter++
@@ -561,22 +1153,30 @@ func collectMetrics() {
 		var disktotal []uint64
 		var disklabels []string
 
+		cfg := currentConfig()
+		disks := cfg.Disks
+
 		// Execute checkDateDirs every 10 seconds
 		if counter >= 60 {
-			availdirs = nil
-			for _, basePath := range yamlconfig.BasePaths {
-				//fmt.Printf("Checking date directories... %s\n", basePath)
-
-				thedirstring, err := constructDirString(basePath)
+			dirtrees = []BasePathTree{}
+			seen := make(map[string]bool)
+			for _, bp := range cfg.BasePaths {
+				root := bp.destinationRoot()
+				if seen[root] {
+					continue
+				}
+				seen[root] = true
+				tree, err := buildTree(cfg, root)
 				if err != nil {
 					fmt.Printf("Error checking directories: %v\n", err)
 				}
-
-				availdirs = append(availdirs, thedirstring)
+				dirtrees = append(dirtrees, tree)
 			}
+			diskmap = cfg.diskMappings()
 			// Reset the counter
 			counter = 0
 		}
+
 		// Get CPU usage per core
 		usages, err := cpu.Percent(0, true) // Get per-core CPU usage, 0 for instantaneous
 		if err != nil {
@@ -584,7 +1184,7 @@ func collectMetrics() {
 			continue
 		}
 
-		for _, disk := range yamlconfig.Disks {
+		for _, disk := range disks {
 			diskUsage, err := getDiskUsage(disk.DiskName)
 			if err != nil {
 				log.Printf("Error getting disk usage: %v", err)
@@ -606,26 +1206,33 @@ func collectMetrics() {
 
 		now := time.Now().UnixMilli()
 		metrics := SystemMetrics{
-			CoreUsages: make([]float64, len(usages)),
-			DiskUsed:   make([]float64, len(diskused)),
-			DiskFree:   make([]float64, len(diskfree)),
-			Timestamp:  now,
-			DiskLabel:  make([]string, len(disklabels)),
-			DiskTotal:  make([]uint64, len(disktotal)),
-			AvailDirs:  make([]string, len(availdirs)),
-			MemoryUsed:  memUsed,
-            MemoryFree:  memFree,
-            MemoryTotal: memTotal,
+			CoreUsages:   make([]float64, len(usages)),
+			DiskUsed:     make([]float64, len(diskused)),
+			DiskFree:     make([]float64, len(diskfree)),
+			Timestamp:    now,
+			DiskLabel:    make([]string, len(disklabels)),
+			DiskTotal:    make([]uint64, len(disktotal)),
+			MoveErrors:   getMoveErrors(),
+			DiskMap:      diskmap,
+			DirTrees:     dirtrees,
+			StreamAlerts: getStreamAlerts(),
+			MemoryUsed:   memUsed,
+			MemoryFree:   memFree,
+			MemoryTotal:  memTotal,
 		}
 
+		// Keep the gauges of /metrics up to date
+		for i, usage := range usages {
+			setGauge("cleanup_cpu_usage_percent", usage, "core", strconv.Itoa(i))
+		}
+		setGauge("cleanup_memory_used_percent", memUsed)
+
 		// Copy current CPU usage to metrics
 		copy(metrics.CoreUsages, usages)    // Usage in percentage (0-100)
 		copy(metrics.DiskUsed, diskused)    // Usage in percentage (0-100)
 		copy(metrics.DiskFree, diskfree)    // Usage in percentage (0-100)
 		copy(metrics.DiskLabel, disklabels) // Usage in percentage (0-100)
 		copy(metrics.DiskTotal, disktotal)  // Usage in percentage (0-100)
-		copy(metrics.AvailDirs, availdirs)
-
 
 		// Add new data to history
 		mutex.Lock()
@@ -759,57 +1366,6 @@ func GetLocalIPs() ([]net.IP, error) {
 	return ips, nil
 }
 
-func constructDirString(basePath string) (string, error) {
-	var availDirs string
-
-	// Get list of year directories
-	years, err := os.ReadDir(basePath)
-
-	if err != nil {
-		return "", err
-	}
-	var basePathstr = basePath + "|"
-	for _, year := range years {
-
-		if !year.IsDir() {
-			continue // Skip if not a directory
-		}
-		yearPath := filepath.Join(basePath, year.Name())
-
-		availDirs = basePathstr + "Y:" + year.Name()
-
-		// Get months for this year
-		months, err := os.ReadDir(yearPath)
-		if err != nil {
-			return "", err
-		}
-
-		for _, month := range months {
-
-			if !month.IsDir() {
-				continue
-			}
-			availDirs += "-M:" + month.Name() + "-D:"
-			monthPath := filepath.Join(yearPath, month.Name())
-
-			// Get days for this month
-			days, err := os.ReadDir(monthPath)
-			if err != nil {
-				return "", err
-			}
-
-			for _, day := range days {
-				if !day.IsDir() {
-					continue
-				}
-				availDirs += day.Name() + ","
-			}
-		}
-	}
-
-	return availDirs, nil
-}
-
 // getMemoryStats returns memory usage statistics
 func getMemoryStats() (used float64, free float64, total uint64, err error) {
 	memory, err := mem.VirtualMemory()
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseFilenameRegex(t *testing.T) {
+	templates := []StructTemplate{
+		{Regex: `^W_XX-EUMETSAT.*MTI1\+FCI-1C.*BODY.*_C_EUMT_(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})(?P<hour>\d{2})(?P<minute>\d{2})`},
+		{Regex: `^OR_ABI-L1b-RadF-M6C(?P<channel>\d\d)_(?P<satellite>G\d\d)_s(?P<year>\d{4})(?P<doy>\d{3})`},
+		{Regex: `^(?P<satellite>[A-Z]+\d)_(?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2})(_(?P<channel>IR\d+))?\.nc$`},
+		{FileTemplate: "avhrr_*_noaa19.hrp.bz2", StartDate: 6, DateLayout: "YYYYMMDD"},
+	}
+	cfg := &Config{YAMLConfig: YAMLConfig{FileTemplates: templates}}
+	for _, template := range templates {
+		re, err := compileFileTemplate(template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Patterns = append(cfg.Patterns, re)
+	}
+
+	tests := []struct {
+		filename string
+		want     *ParsedName // nil when the file is not matched
+		wantErr  bool
+	}{
+		{
+			"W_XX-EUMETSAT-Darmstadt,IMG+SAT,MTI1+FCI-1C-RRAD-FDHSI-FD--CHK-BODY--DIS-NC4E_C_EUMT_20250314123015_IDPFI_OPE_20250314122007_20250314122017_N__O_0075_0001.nc",
+			&ParsedName{Template: 0, Date: "20250314", Year: "2025", Month: "03", Day: "14", Doy: "073", Hour: "12", Minute: "30"},
+			false,
+		},
+		{
+			"OR_ABI-L1b-RadF-M6C13_G16_s20250731200207_e20250731209527_c20250731209590.nc",
+			&ParsedName{Template: 1, Date: "2025073", Year: "2025", Month: "03", Day: "14", Doy: "073", Satellite: "G16", Channel: "13"},
+			false,
+		},
+		{
+			"OR_ABI-L1b-RadF-M6C13_G16_s20243661200207.nc",
+			&ParsedName{Template: 1, Date: "2024366", Year: "2024", Month: "12", Day: "31", Doy: "366", Satellite: "G16", Channel: "13"},
+			false,
+		},
+		{
+			"MSG4_2025-03-14_IR108.nc",
+			&ParsedName{Template: 2, Date: "20250314", Year: "2025", Month: "03", Day: "14", Doy: "073", Satellite: "MSG4", Channel: "IR108"},
+			false,
+		},
+		// An optional group that does not match leaves its value empty.
+		{
+			"MSG4_2025-03-14.nc",
+			&ParsedName{Template: 2, Date: "20250314", Year: "2025", Month: "03", Day: "14", Doy: "073", Satellite: "MSG4"},
+			false,
+		},
+		{
+			"avhrr_20250314_noaa19.hrp.bz2",
+			&ParsedName{Template: 3, Date: "20250314", Year: "2025", Month: "03", Day: "14", Doy: "073"},
+			false,
+		},
+		{"MSG4_2025-02-30.nc", nil, true},
+		{"MSG4_2025-13-01.nc", nil, true},
+		{"unknown_20250314.nc", nil, false},
+	}
+	for _, test := range tests {
+		got, _, err := cfg.parseFilename(test.filename)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseFilename(%s) error = %v, want error %v", test.filename, err, test.wantErr)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+		if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+			t.Errorf("parseFilename(%s) = %+v, want %+v", test.filename, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Collision policies for a file that already exists at its destination.
+const (
+	CollisionOverwrite = "overwrite" // Replace the existing file (default)
+	CollisionNewest    = "newest"    // Keep the file with the latest modification time
+	CollisionLarger    = "larger"    // Keep the larger file
+	CollisionRename    = "rename"    // Keep both, the incoming file gets a numbered suffix
+	CollisionChecksum  = "checksum"  // Drop exact duplicates, rename the others
+)
+
+// checkCollisionPolicy returns an error for an unknown collision policy.
+func checkCollisionPolicy(policy string) error {
+	switch policy {
+	case "", CollisionOverwrite, CollisionNewest, CollisionLarger, CollisionRename, CollisionChecksum:
+		return nil
+	}
+	return fmt.Errorf("unknown collision policy %q", policy)
+}
+
+// resolveCollision applies the collision policy when newPath already exists.
+// It returns the path the incoming file fullPath must be moved to, or "" when
+// the incoming file must be dropped. Every decision is logged.
+func resolveCollision(policy, fullPath, newPath string) (string, error) {
+	existing, err := os.Stat(newPath)
+	if os.IsNotExist(err) {
+		return newPath, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %v", newPath, err)
+	}
+	incoming, err := os.Stat(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %v", fullPath, err)
+	}
+
+	switch policy {
+	case CollisionNewest:
+		if incoming.ModTime().Before(existing.ModTime()) {
+			fmt.Printf("Collision %s: existing file is newer, dropping incoming %s\n", newPath, fullPath)
+			return "", nil
+		}
+		fmt.Printf("Collision %s: incoming file is newer, overwriting\n", newPath)
+		return newPath, nil
+
+	case CollisionLarger:
+		if incoming.Size() <= existing.Size() {
+			fmt.Printf("Collision %s: existing file is not smaller (%d >= %d bytes), dropping incoming %s\n",
+				newPath, existing.Size(), incoming.Size(), fullPath)
+			return "", nil
+		}
+		fmt.Printf("Collision %s: incoming file is larger (%d > %d bytes), overwriting\n", newPath, incoming.Size(), existing.Size())
+		return newPath, nil
+
+	case CollisionRename:
+		renamed := uniquePath(newPath)
+		fmt.Printf("Collision %s: keeping both, incoming file renamed to %s\n", newPath, filepath.Base(renamed))
+		return renamed, nil
+
+	case CollisionChecksum:
+		if incoming.Size() == existing.Size() {
+			incomingSum, err := fileChecksum(fullPath)
+			if err != nil {
+				return "", err
+			}
+			existingSum, err := fileChecksum(newPath)
+			if err != nil {
+				return "", err
+			}
+			if bytes.Equal(incomingSum, existingSum) {
+				fmt.Printf("Collision %s: exact duplicate, dropping incoming %s\n", newPath, fullPath)
+				return "", nil
+			}
+		}
+		renamed := uniquePath(newPath)
+		fmt.Printf("Collision %s: contents differ, incoming file renamed to %s\n", newPath, filepath.Base(renamed))
+		return renamed, nil
+	}
+
+	fmt.Printf("Collision %s: overwriting existing file\n", newPath)
+	return newPath, nil
+}
+
+// uniquePath returns the first path that does not exist yet by inserting _1,
+// _2, ... before the extensions of the filename, e.g. name_1.hrp.bz2.
+func uniquePath(path string) string {
+	dir, filename := filepath.Split(path)
+	stem, ext := filename, ""
+	if i := strings.Index(filename, "."); i > 0 {
+		stem, ext = filename[:i], filename[i:]
+	}
+	for n := 1; ; n++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s_%d%s", stem, n, ext))
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResolveCollision(t *testing.T) {
+	older := time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	// file is a file to create with its content and modification time.
+	type file struct {
+		content string
+		mtime   time.Time
+	}
+	tests := []struct {
+		policy   string
+		incoming file
+		existing *file // nil when the destination is free
+		taken    []string
+		want     string // "" drops the incoming file
+	}{
+		{CollisionNewest, file{"a", older}, nil, nil, "name.hrp.bz2"},
+		{CollisionRename, file{"a", older}, nil, nil, "name.hrp.bz2"},
+
+		{"", file{"a", older}, &file{"b", newer}, nil, "name.hrp.bz2"},
+		{CollisionOverwrite, file{"a", older}, &file{"b", newer}, nil, "name.hrp.bz2"},
+
+		{CollisionNewest, file{"a", newer}, &file{"b", older}, nil, "name.hrp.bz2"},
+		{CollisionNewest, file{"a", older}, &file{"b", newer}, nil, ""},
+		{CollisionNewest, file{"a", older}, &file{"b", older}, nil, "name.hrp.bz2"},
+
+		{CollisionLarger, file{"aaa", older}, &file{"bb", older}, nil, "name.hrp.bz2"},
+		{CollisionLarger, file{"aa", older}, &file{"bb", older}, nil, ""},
+		{CollisionLarger, file{"a", newer}, &file{"bb", older}, nil, ""},
+
+		{CollisionRename, file{"a", older}, &file{"a", older}, nil, "name_1.hrp.bz2"},
+		{CollisionRename, file{"a", older}, &file{"b", older}, []string{"name_1.hrp.bz2"}, "name_2.hrp.bz2"},
+
+		{CollisionChecksum, file{"same", newer}, &file{"same", older}, nil, ""},
+		{CollisionChecksum, file{"abcd", older}, &file{"abce", older}, nil, "name_1.hrp.bz2"},
+		{CollisionChecksum, file{"abc", older}, &file{"abcd", older}, []string{"name_1.hrp.bz2"}, "name_2.hrp.bz2"},
+	}
+	for i, test := range tests {
+		dir := t.TempDir()
+		fullPath := filepath.Join(dir, "incoming", "name.hrp.bz2")
+		newPath := filepath.Join(dir, "20250314", "name.hrp.bz2")
+		writeFile(t, fullPath, test.incoming.content, test.incoming.mtime)
+		if test.existing != nil {
+			writeFile(t, newPath, test.existing.content, test.existing.mtime)
+		}
+		for _, name := range test.taken {
+			writeFile(t, filepath.Join(dir, "20250314", name), "", older)
+		}
+
+		got, err := resolveCollision(test.policy, fullPath, newPath)
+		if err != nil {
+			t.Errorf("%d: resolveCollision(%q): %v", i, test.policy, err)
+			continue
+		}
+		want := ""
+		if test.want != "" {
+			want = filepath.Join(dir, "20250314", test.want)
+		}
+		if got != want {
+			t.Errorf("%d: resolveCollision(%q) = %q, want %q", i, test.policy, got, want)
+		}
+	}
+}
+
+// writeFile creates the file path with content and modification time mtime.
+func writeFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configPath is the configuration file read at startup and on every reload.
+var configPath = "directories.yaml"
+
+// forceDryRun is set by the -dryrun flag and survives reloads.
+var forceDryRun bool
+
+// configMutex guards yamlconfig and regexPatterns. A reload swaps them under
+// the write lock; everything else takes a snapshot with currentConfig and
+// works on that, so a reload never waits for a pass over the filesystem.
+var configMutex sync.RWMutex
+
+// Config is a snapshot of the running configuration: the YAML configuration
+// and the compiled regex pattern of every file template. A reload replaces
+// yamlconfig and regexPatterns but never changes what they hold, so a
+// snapshot stays consistent without a lock.
+type Config struct {
+	YAMLConfig
+	Patterns []*regexp.Regexp
+}
+
+// currentConfig returns a snapshot of the running configuration.
+func currentConfig() *Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return &Config{YAMLConfig: yamlconfig, Patterns: regexPatterns}
+}
+
+// loadConfig reads, validates and parses a configuration file and compiles
+// the regex pattern of every file template. Warnings are printed, errors make
+// the whole configuration invalid.
+func loadConfig(path string) (YAMLConfig, []*regexp.Regexp, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return YAMLConfig{}, nil, fmt.Errorf("error reading YAML file: %v", err)
+	}
+
+	// Parse and validate the YAML content
+	config, problems := validateConfig(data)
+	var errs []string
+	for _, problem := range problems {
+		if problem.Warning {
+			fmt.Printf("%s:%s\n", path, problem)
+			continue
+		}
+		errs = append(errs, path+":"+problem.String())
+	}
+	if len(errs) > 0 {
+		return config, nil, fmt.Errorf("invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	// The command line flag can only switch dry-run mode on.
+	if forceDryRun {
+		config.DryRun = true
+	}
+
+	// Compile regex patterns for each filetemplate
+	patterns := make([]*regexp.Regexp, 0, len(config.FileTemplates))
+	for _, template := range config.FileTemplates {
+		re, err := compileFileTemplate(template)
+		if err != nil {
+			return config, nil, err
+		}
+		patterns = append(patterns, re)
+	}
+	return config, patterns, nil
+}
+
+// listenAddress returns the host:port the web server listens on.
+func listenAddress(config YAMLConfig) string {
+	port := config.PortNumber
+	if port == "" {
+		port = portnumber
+	}
+	return net.JoinHostPort(config.ListenAddress, port)
+}
+
+// compileFileTemplate checks a file template and compiles its regex pattern.
+func compileFileTemplate(template StructTemplate) (*regexp.Regexp, error) {
+	if err := checkDestinationLayout(destinationLayout(template)); err != nil {
+		return nil, fmt.Errorf("invalid destination %s: %v", template.Destination, err)
+	}
+	if err := checkCollisionPolicy(template.Collision); err != nil {
+		return nil, err
+	}
+
+	if template.Regex != "" {
+		re, err := compileNamedTemplate(template.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s: %v", template.Regex, err)
+		}
+		groups := make(map[string]bool)
+		for _, name := range re.SubexpNames() {
+			groups[name] = true
+		}
+		if err := checkLayoutValues(destinationLayout(template), groups); err != nil {
+			return nil, fmt.Errorf("invalid destination %s: %v", template.Destination, err)
+		}
+		return re, nil
+	}
+	if err := checkLayoutValues(destinationLayout(template), nil); err != nil {
+		return nil, fmt.Errorf("invalid destination %s: %v", template.Destination, err)
+	}
+
+	// Convert glob-like patterns to regex (replace "*" with ".*")
+	escaped := regexp.QuoteMeta(template.FileTemplate)
+	escaped = strings.ReplaceAll(escaped, `\.`, `.`)
+	pattern := "^" + strings.ReplaceAll(escaped, `\*`, `.*`) + "$"
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %v", template.FileTemplate, err)
+	}
+	return re, nil
+}
+
+// printConfig prints the file templates and disks of a configuration.
+func printConfig(cfg *Config) {
+	fmt.Println("File Templates:")
+	for i, template := range cfg.FileTemplates {
+		if template.Regex != "" {
+			fmt.Printf("  %d: regex %s\n", i+1, template.Regex)
+			continue
+		}
+		fmt.Printf("  %d: %s %d %s\n", i+1, template.FileTemplate, template.StartDate, template.DateLayout)
+	}
+	fmt.Println("Disks:")
+	for _, mapping := range cfg.diskMappings() {
+		if mapping.Mount == "" {
+			fmt.Printf("  on no configured disk, never cleaned: %s\n", strings.Join(mapping.BasePaths, ", "))
+			continue
+		}
+		fmt.Printf("  %s (mount %s): %s\n", mapping.Disk, mapping.Mount, strings.Join(mapping.BasePaths, ", "))
+	}
+	if cfg.DryRun {
+		fmt.Println("Dry-run mode: no files or directories will be moved or deleted")
+	}
+}
+
+// reloadConfig validates the configuration file and, when it is valid, swaps
+// it in for the running configuration. An invalid file leaves the running
+// configuration untouched.
+func reloadConfig(watcher *basePathWatcher) error {
+	config, patterns, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Printf("Error reloading %s, keeping the running configuration: %v\n", configPath, err)
+		return err
+	}
+
+	configMutex.Lock()
+	oldAddr := listenAddress(yamlconfig)
+	oldCatalog := catalogPath(yamlconfig)
+	yamlconfig = config
+	regexPatterns = patterns
+	configMutex.Unlock()
+	notifier.configure(config.Notifications, config.DryRun)
+
+	fmt.Printf("Reloaded %s\n", configPath)
+	if addr := listenAddress(config); addr != oldAddr {
+		fmt.Printf("Listen address changed from %s to %s, restart to apply\n", oldAddr, addr)
+	}
+	if path := catalogPath(config); path != oldCatalog {
+		fmt.Printf("Catalog changed from %s to %s, restart to apply\n", oldCatalog, path)
+	}
+	printConfig(&Config{YAMLConfig: config, Patterns: patterns})
+
+	if watcher != nil {
+		watcher.syncWatches()
+	}
+	return nil
+}
+
+// handleReloadSignals reloads the configuration on SIGHUP.
+func handleReloadSignals(watcher *basePathWatcher) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		fmt.Println("Received SIGHUP")
+		reloadConfig(watcher)
+	}
+}
+
+// reloadHandler serves POST /api/reload.
+func reloadHandler(watcher *basePathWatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		type reloadResult struct {
+			Status string `json:"status"`
+			Error  string `json:"error,omitempty"`
+		}
+		result := reloadResult{Status: "reloaded"}
+		status := http.StatusOK
+		if err := reloadConfig(watcher); err != nil {
+			result = reloadResult{Status: "rejected", Error: err.Error()}
+			status = http.StatusBadRequest
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// watchConfigFile reloads the configuration when the configuration file is
+// written or replaced. The directory is watched, because editors often save
+// by writing a new file and renaming it over the old one.
+func watchConfigFile(watcher *basePathWatcher) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		fmt.Printf("Error watching %s: %v\n", configPath, err)
+		return
+	}
+	defer syscall.Close(fd)
+
+	dir, name := filepath.Split(configPath)
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+		fmt.Printf("Error watching %s: %v\n", configPath, err)
+		return
+	}
+
+	// An editor can cause several events for one save, so wait until the
+	// file has been quiet for a moment before reloading.
+	var debounce *time.Timer
+	buf := make([]byte, 4096)
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			fmt.Printf("Error reading inotify events for %s: %v\n", configPath, err)
+			return
+		}
+		parseInotifyEvents(buf[:n], func(event *syscall.InotifyEvent, eventName string) {
+			if eventName != name {
+				return
+			}
+			if debounce != nil {
+				debounce.Stop()
+			}
+			debounce = time.AfterFunc(time.Second, func() {
+				fmt.Printf("%s changed\n", configPath)
+				reloadConfig(watcher)
+			})
+		})
+	}
+}
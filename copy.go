@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// renameFile moves src to dst. When they are on different filesystems the
+// rename fails with EXDEV and the file is copied instead: the copy is written
+// to a temporary file next to dst, synced to disk, optionally verified with a
+// SHA-256 checksum and renamed into place before src is removed.
+func renameFile(src, dst string, verify bool) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	start := time.Now()
+	size, err := copyFile(src, dst, verify)
+	if err != nil {
+		fmt.Printf("Failed to copy %s to %s: %v\n", src, dst, err)
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		return fmt.Errorf("copied %s to %s but failed to remove the source: %v", src, dst, err)
+	}
+	fmt.Printf("Copied %s to %s (%d bytes in %v, verified: %t)\n", src, dst, size, time.Since(start).Round(time.Millisecond), verify)
+	return nil
+}
+
+// copyFile copies src to dst through a temporary file and returns the number
+// of bytes copied. The modification time and permissions of src are kept.
+func copyFile(src, dst string, verify bool) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".copy")
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	// Remove the temporary file on any failure below.
+	defer os.Remove(tmp)
+
+	srcHash := sha256.New()
+	size, err := io.Copy(out, io.TeeReader(in, srcHash))
+	if err != nil {
+		out.Close()
+		return 0, err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return 0, fmt.Errorf("fsync %s: %v", tmp, err)
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	if size != info.Size() {
+		return 0, fmt.Errorf("copied %d bytes, expected %d", size, info.Size())
+	}
+
+	if verify {
+		dstHash, err := fileChecksum(tmp)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(srcHash.Sum(nil), dstHash) {
+			return 0, fmt.Errorf("checksum mismatch after copy")
+		}
+	}
+
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return 0, err
+	}
+	syncDir(filepath.Dir(dst))
+	return size, nil
+}
+
+// fileChecksum returns the SHA-256 checksum of a file.
+func fileChecksum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// syncDir flushes a directory so that a rename into it survives a crash.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+)
+
+// DiskMapping shows which basepaths are cleaned for a disk, in the web
+// interface.
+type DiskMapping struct {
+	Disk      string   `json:"disk"`
+	Mount     string   `json:"mount"`     // Mount point of the filesystem holding the disk
+	BasePaths []string `json:"basepaths"` // Destination roots on the same filesystem
+}
+
+// deviceOf returns the device of the filesystem holding path, after
+// resolving symbolic links.
+func deviceOf(path string) (uint64, string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return 0, "", err
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Stat(resolved, &stat); err != nil {
+		return 0, "", err
+	}
+	return uint64(stat.Dev), resolved, nil
+}
+
+// mountPointOf returns the mount point of the filesystem holding path, the
+// highest directory above it on the same device.
+func mountPointOf(path string) (string, error) {
+	dev, dir, err := deviceOf(path)
+	if err != nil {
+		return "", err
+	}
+	for dir != "/" {
+		parent := filepath.Dir(dir)
+		var stat syscall.Stat_t
+		if err := syscall.Stat(parent, &stat); err != nil {
+			return "", err
+		}
+		if uint64(stat.Dev) != dev {
+			break
+		}
+		dir = parent
+	}
+	return dir, nil
+}
+
+// onDisk reports whether deleting below root frees space on the disk, because
+// both are on the same filesystem. When either cannot be examined, it falls
+// back to comparing the paths.
+func onDisk(root, diskName string) bool {
+	rootDev, _, err := deviceOf(root)
+	if err != nil {
+		return isBelow(root, diskName)
+	}
+	diskDev, _, err := deviceOf(diskName)
+	if err != nil {
+		return isBelow(root, diskName)
+	}
+	return rootDev == diskDev
+}
+
+// diskBasePaths returns the destination roots of the basepaths on a disk.
+func (cfg *Config) diskBasePaths(diskName string) []string {
+	var roots []string
+	seen := make(map[string]bool)
+	for _, bp := range cfg.BasePaths {
+		root := bp.destinationRoot()
+		if seen[root] {
+			continue
+		}
+		seen[root] = true
+		if onDisk(root, diskName) {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// diskMappings returns the basepaths of every disk. Basepaths that are not
+// on any configured disk are listed under the disk "(none)", they are never
+// cleaned for disk space.
+func (cfg *Config) diskMappings() []DiskMapping {
+	var mappings []DiskMapping
+	mapped := make(map[string]bool)
+	for _, thedisk := range cfg.Disks {
+		mount, err := mountPointOf(thedisk.DiskName)
+		if err != nil {
+			mount = fmt.Sprintf("unknown (%v)", err)
+		}
+		roots := cfg.diskBasePaths(thedisk.DiskName)
+		for _, root := range roots {
+			mapped[root] = true
+		}
+		mappings = append(mappings, DiskMapping{Disk: thedisk.DiskName, Mount: mount, BasePaths: roots})
+	}
+
+	unmapped := DiskMapping{Disk: "(none)"}
+	for _, bp := range cfg.BasePaths {
+		if root := bp.destinationRoot(); !mapped[root] {
+			mapped[root] = true
+			unmapped.BasePaths = append(unmapped.BasePaths, root)
+		}
+	}
+	if len(unmapped.BasePaths) > 0 {
+		mappings = append(mappings, unmapped)
+	}
+	return mappings
+}
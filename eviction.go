@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Eviction strategies, which day directory to delete next when a disk is below
+// its free space threshold.
+const (
+	EvictionOldest      = "oldest"      // The oldest directory of all basepaths on the disk (default)
+	EvictionWeighted    = "weighted"    // From the basepath using most space relative to its weight
+	EvictionBytesPerDay = "bytesperday" // Reclaim from each basepath in proportion to its bytes per day
+	EvictionPriority    = "priority"    // The oldest directory of the lowest priority tier first
+)
+
+// checkEvictionStrategy returns an error for an unknown eviction strategy.
+func checkEvictionStrategy(strategy string) error {
+	switch strategy {
+	case "", EvictionOldest, EvictionWeighted, EvictionBytesPerDay, EvictionPriority:
+		return nil
+	}
+	return fmt.Errorf("unknown eviction strategy %q", strategy)
+}
+
+// streamSettings returns the weight and priority of the basepaths filed below
+// a destination root. The weight defaults to 1.
+func (cfg *Config) streamSettings(root string) (weight, priority int) {
+	for _, bp := range cfg.BasePaths {
+		if bp.destinationRoot() != root {
+			continue
+		}
+		weight = max(weight, bp.Weight)
+		priority = max(priority, bp.Priority)
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	return weight, priority
+}
+
+// evictionState keeps track of one pass of deleteOldDirectories over a disk.
+type evictionState struct {
+	cfg       *Config
+	strategy  string
+	sizes     map[string]DirUsage // Usage of every day directory seen, by path
+	reclaimed map[string]int64    // Bytes reclaimed in this pass, by destination root
+}
+
+func newEvictionState(cfg *Config, strategy string) *evictionState {
+	if strategy == "" {
+		strategy = EvictionOldest
+	}
+	return &evictionState{
+		cfg:       cfg,
+		strategy:  strategy,
+		sizes:     make(map[string]DirUsage),
+		reclaimed: make(map[string]int64),
+	}
+}
+
+// usage returns the usage of a day directory, walking it only once per pass.
+func (s *evictionState) usage(dir string) (DirUsage, error) {
+	if usage, ok := s.sizes[dir]; ok {
+		return usage, nil
+	}
+	usage, err := dirUsage(dir)
+	if err != nil {
+		return DirUsage{}, fmt.Errorf("error sizing directory %s: %v", dir, err)
+	}
+	s.sizes[dir] = usage
+	return usage, nil
+}
+
+// pick returns the day directory to delete next out of the candidates.
+func (s *evictionState) pick(directories []DirectoryInfo) (DirectoryInfo, error) {
+	// Oldest first, so the first directory of a basepath is its oldest one.
+	sort.SliceStable(directories, func(i, j int) bool {
+		return directories[i].ModTime < directories[j].ModTime
+	})
+
+	switch s.strategy {
+	case EvictionPriority:
+		lowest := 0
+		for i, dir := range directories {
+			_, priority := s.cfg.streamSettings(dir.BasePath)
+			_, lowestPriority := s.cfg.streamSettings(directories[lowest].BasePath)
+			if priority < lowestPriority {
+				lowest = i
+			}
+		}
+		return directories[lowest], nil
+
+	case EvictionWeighted, EvictionBytesPerDay:
+		used := make(map[string]int64)
+		days := make(map[string]int)
+		oldest := make(map[string]DirectoryInfo)
+		var roots []string
+		for _, dir := range directories {
+			usage, err := s.usage(dir.Path)
+			if err != nil {
+				return DirectoryInfo{}, err
+			}
+			if _, ok := oldest[dir.BasePath]; !ok {
+				oldest[dir.BasePath] = dir
+				roots = append(roots, dir.BasePath)
+			}
+			used[dir.BasePath] += usage.Bytes
+			days[dir.BasePath]++
+		}
+
+		// Roots are in order of their oldest directory, so ties go to the
+		// basepath with the oldest data.
+		best, bestScore := "", 0.0
+		for _, root := range roots {
+			var score float64
+			if s.strategy == EvictionWeighted {
+				// Space used relative to the weight, the largest goes first.
+				weight, _ := s.cfg.streamSettings(root)
+				score = float64(used[root]) / float64(weight)
+			} else {
+				// Days reclaimed so far, the fewest goes first.
+				bytesPerDay := float64(used[root]) / float64(days[root])
+				if bytesPerDay == 0 {
+					bytesPerDay = 1
+				}
+				score = -float64(s.reclaimed[root]) / bytesPerDay
+			}
+			if best == "" || score > bestScore {
+				best, bestScore = root, score
+			}
+		}
+		return oldest[best], nil
+	}
+
+	return directories[0], nil
+}
+
+// deleted records that a day directory of a root was deleted in this pass.
+func (s *evictionState) deleted(dir DirectoryInfo, size int64) {
+	s.reclaimed[dir.BasePath] += size
+	delete(s.sizes, dir.Path)
+}
+
+// StreamReclaim counts the space reclaimed from one destination root since
+// the last status report.
+type StreamReclaim struct {
+	Directories  int   // Deleted to free disk space
+	Bytes        int64 // Reclaimed to free disk space
+	Expired      int   // Deleted by the maximum retention
+	ExpiredBytes int64 // Reclaimed by the maximum retention
+}
+
+// reclaimMutex guards reclaimed.
+var reclaimMutex sync.Mutex
+var reclaimed = make(map[string]*StreamReclaim)
+
+// recordReclaim adds a deleted day directory to the daily status report.
+func recordReclaim(root string, size int64, expired bool) {
+	reason := "disk"
+	if expired {
+		reason = "retention"
+	}
+	addCounter("cleanup_directories_evicted_total", 1, "basepath", root, "reason", reason)
+	addCounter("cleanup_bytes_reclaimed_total", float64(size), "basepath", root, "reason", reason)
+
+	reclaimMutex.Lock()
+	defer reclaimMutex.Unlock()
+	stream, ok := reclaimed[root]
+	if !ok {
+		stream = &StreamReclaim{}
+		reclaimed[root] = stream
+	}
+	if expired {
+		stream.Expired++
+		stream.ExpiredBytes += size
+	} else {
+		stream.Directories++
+		stream.Bytes += size
+	}
+}
+
+// printStatusReport prints, for every disk and destination root, the free
+// space, the days kept and the space reclaimed since the last report, and
+// starts a new report.
+func printStatusReport(day string) {
+	reclaimMutex.Lock()
+	streams := reclaimed
+	reclaimed = make(map[string]*StreamReclaim)
+	reclaimMutex.Unlock()
+
+	cfg := currentConfig()
+	fmt.Printf("Status report %s\n", day)
+	for _, thedisk := range cfg.Disks {
+		strategy := thedisk.Eviction
+		if strategy == "" {
+			strategy = EvictionOldest
+		}
+		limits, _ := limitsFor(thedisk)
+		state, err := statDisk(thedisk.DiskName)
+		if err != nil {
+			fmt.Printf("  Disk %s: %v\n", thedisk.DiskName, err)
+		} else {
+			fmt.Printf("  Disk %s: %s, required %s, eviction %s\n", thedisk.DiskName, state, limits, strategy)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, bp := range cfg.BasePaths {
+		root := bp.destinationRoot()
+		if seen[root] {
+			continue
+		}
+		seen[root] = true
+
+		kept := "no day directories"
+		if directories, err := cfg.dayDirectories(root); err == nil && len(directories) > 0 {
+			first := directories[0].ModTime
+			for _, dir := range directories {
+				first = min(first, dir.ModTime)
+			}
+			kept = fmt.Sprintf("%d day directories since %d", len(directories), first)
+		}
+		stream := streams[root]
+		if stream == nil {
+			stream = &StreamReclaim{}
+		}
+		fmt.Printf("  %s: %s, deleted for disk space %d (%.2f GiB), expired %d (%.2f GiB)\n",
+			root, kept, stream.Directories, float64(stream.Bytes)/(1<<30),
+			stream.Expired, float64(stream.ExpiredBytes)/(1<<30))
+	}
+}
+
+// eventStatusReport prints the status report of the previous day every
+// midnight.
+func eventStatusReport(done chan bool) {
+	for {
+		now := time.Now()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		select {
+		case <-done:
+			return
+		case <-time.After(midnight.Sub(now)):
+			printStatusReport(now.Format("2006-01-02"))
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultGapFactor is the number of missed files after which a stream with a
+// cadence but without maxgap counts as stalled.
+const defaultGapFactor = 3
+
+// maxGap returns how long a template may go without a file before its stream
+// counts as stalled, or 0 when the stream is not watched.
+func maxGap(template StructTemplate) time.Duration {
+	if template.MaxGap > 0 {
+		return time.Duration(template.MaxGap) * time.Second
+	}
+	return time.Duration(template.Cadence*defaultGapFactor) * time.Second
+}
+
+// streamState is the ingest state of the product stream of one template.
+type streamState struct {
+	lastSeen time.Time
+	count    int       // Files filed since the start of the service
+	stalled  time.Time // When the stall was detected, zero while data arrives
+}
+
+// StreamAlert is a stalled product stream, shown in the web interface.
+type StreamAlert struct {
+	Template string    `json:"template"`
+	LastSeen time.Time `json:"last_seen"` // Last file, or when watching started
+	Since    time.Time `json:"since"`     // When the stall was detected
+	MaxGap   string    `json:"max_gap"`
+	Files    int       `json:"files"` // Files filed since the start of the service
+}
+
+// streamsMutex guards streams.
+var streamsMutex sync.Mutex
+var streams = make(map[string]*streamState)
+
+// recordArrival notes that a file of a template was filed. A stalled stream
+// is cleared.
+func recordArrival(template string, when time.Time) {
+	streamsMutex.Lock()
+	defer streamsMutex.Unlock()
+	stream, ok := streams[template]
+	if !ok {
+		stream = &streamState{}
+		streams[template] = stream
+	}
+	if !stream.stalled.IsZero() {
+		fmt.Printf("Stream resumed: %s, first file after %v\n", template, when.Sub(stream.lastSeen).Round(time.Second))
+		stream.stalled = time.Time{}
+		setGauge("cleanup_stream_stalled", 0, "template", template)
+		notifier.resolve(EventStreamStalled, template)
+	}
+	stream.lastSeen = when
+	stream.count++
+}
+
+// seedStreams starts the streams of the watched templates from the last
+// arrival in the catalog, or from now when the catalog does not know them.
+func seedStreams() {
+	lastArrival := make(map[string]time.Time)
+	if stats, err := catalog.stats(); err == nil {
+		for _, stat := range stats {
+			if t, err := time.Parse(time.RFC3339, stat.LastArrival); err == nil {
+				lastArrival[stat.Template] = t
+			}
+		}
+	}
+
+	cfg := currentConfig()
+	streamsMutex.Lock()
+	defer streamsMutex.Unlock()
+	now := time.Now()
+	for _, template := range cfg.FileTemplates {
+		name := templateName(template)
+		if maxGap(template) == 0 || streams[name] != nil {
+			continue
+		}
+		lastSeen, ok := lastArrival[name]
+		if !ok {
+			lastSeen = now
+		}
+		streams[name] = &streamState{lastSeen: lastSeen}
+	}
+}
+
+// checkStreams raises an alert for every watched stream whose last file is
+// older than its maximum gap. In dry-run mode nothing is filed, so no stream
+// is judged: the streams are kept alive and alerts are cleared.
+func checkStreams(now time.Time) {
+	cfg := currentConfig()
+	streamsMutex.Lock()
+	defer streamsMutex.Unlock()
+
+	for _, template := range cfg.FileTemplates {
+		gap := maxGap(template)
+		if gap == 0 {
+			continue
+		}
+		name := templateName(template)
+		stream, ok := streams[name]
+		if !ok {
+			// Added by a reload, start watching now.
+			streams[name] = &streamState{lastSeen: now}
+			continue
+		}
+		if cfg.DryRun {
+			// Keep the stream alive, so that watching starts afresh when
+			// dry-run mode is switched off.
+			stream.lastSeen = now
+			if !stream.stalled.IsZero() {
+				stream.stalled = time.Time{}
+				setGauge("cleanup_stream_stalled", 0, "template", name)
+				notifier.resolve(EventStreamStalled, name)
+			}
+			continue
+		}
+		if !stream.stalled.IsZero() || now.Sub(stream.lastSeen) <= gap {
+			continue
+		}
+		stream.stalled = now
+		setGauge("cleanup_stream_stalled", 1, "template", name)
+		fmt.Printf("Stream stalled: %s, no file for %v (max gap %v)\n", name, now.Sub(stream.lastSeen).Round(time.Second), gap)
+		notifier.notify(EventStreamStalled, name, "No file of template %s for %v, the maximum gap is %v", name, now.Sub(stream.lastSeen).Round(time.Second), gap)
+	}
+}
+
+// getStreamAlerts returns the stalled streams of the watched templates.
+func getStreamAlerts() []StreamAlert {
+	cfg := currentConfig()
+	streamsMutex.Lock()
+	defer streamsMutex.Unlock()
+
+	alerts := []StreamAlert{}
+	for _, template := range cfg.FileTemplates {
+		gap := maxGap(template)
+		stream, ok := streams[templateName(template)]
+		if gap == 0 || !ok || stream.stalled.IsZero() {
+			continue
+		}
+		alerts = append(alerts, StreamAlert{
+			Template: templateName(template),
+			LastSeen: stream.lastSeen,
+			Since:    stream.stalled,
+			MaxGap:   gap.String(),
+			Files:    stream.count,
+		})
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Since.Before(alerts[j].Since)
+	})
+	return alerts
+}
+
+// eventCheckStreams checks the watched streams every 30 seconds.
+func eventCheckStreams(done chan bool) {
+	seedStreams()
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			checkStreams(now)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckStreams(t *testing.T) {
+	const name = "AVHR_*.bz2"
+	now := time.Date(2025, 3, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		dryRun      bool
+		lastSeen    time.Duration // Before now
+		wantStalled bool
+	}{
+		{false, 10 * time.Minute, false},
+		{false, time.Hour, true},
+		{true, time.Hour, false},
+	}
+	for _, test := range tests {
+		configMutex.Lock()
+		yamlconfig = YAMLConfig{DryRun: test.dryRun, FileTemplates: []StructTemplate{{FileTemplate: name, MaxGap: 900}}}
+		configMutex.Unlock()
+		streamsMutex.Lock()
+		streams = map[string]*streamState{name: {lastSeen: now.Add(-test.lastSeen)}}
+		streamsMutex.Unlock()
+
+		checkStreams(now)
+		stalled := len(getStreamAlerts()) > 0
+		if stalled != test.wantStalled {
+			t.Errorf("dry-run %v, last file %v ago: stalled = %v, want %v", test.dryRun, test.lastSeen, stalled, test.wantStalled)
+		}
+	}
+	yamlconfig = YAMLConfig{}
+}
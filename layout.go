@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDestination is the destination layout used by templates that do not
+// define their own.
+const defaultDestination = "{yyyy}/{mm}/{dd}"
+
+// layoutPlaceholders maps every destination placeholder to the glob used to
+// find directories created with it.
+var layoutPlaceholders = map[string]string{
+	"{yyyy}":      "????",
+	"{mm}":        "??",
+	"{dd}":        "??",
+	"{doy}":       "???",
+	"{hh}":        "??",
+	"{mi}":        "??",
+	"{satellite}": "*",
+	"{channel}":   "*",
+}
+
+// placeholderGroups maps every placeholder that does not come from the date to
+// the named regex group that supplies its value.
+var placeholderGroups = map[string]string{
+	"{hh}":        "hour",
+	"{mi}":        "minute",
+	"{satellite}": "satellite",
+	"{channel}":   "channel",
+}
+
+// destinationLayout returns the destination layout of a file template.
+func destinationLayout(template StructTemplate) string {
+	if template.Destination == "" {
+		return defaultDestination
+	}
+	return template.Destination
+}
+
+// checkDestinationLayout verifies that every path component of a layout is
+// either literal text or a single placeholder, and that the layout contains a
+// complete date.
+func checkDestinationLayout(layout string) error {
+	components := strings.Split(layout, "/")
+	seen := make(map[string]bool)
+	for _, component := range components {
+		if component == "" || component == "." || component == ".." {
+			return fmt.Errorf("invalid path component %q", component)
+		}
+		if _, ok := layoutPlaceholders[component]; ok {
+			seen[component] = true
+			continue
+		}
+		if strings.ContainsAny(component, "{}*?[") {
+			return fmt.Errorf("unknown placeholder or wildcard in %q", component)
+		}
+	}
+	if !seen["{yyyy}"] || (!seen["{doy}"] && (!seen["{mm}"] || !seen["{dd}"])) {
+		return fmt.Errorf("layout needs {yyyy} together with {mm} and {dd}, or {doy}")
+	}
+	return nil
+}
+
+// checkLayoutValues verifies that a template can fill in every placeholder of
+// its destination layout. The date placeholders are always known; the others
+// need a regex template with the matching named group. groups holds the named
+// groups of a regex template and is nil for a filetemplate with startdate.
+func checkLayoutValues(layout string, groups map[string]bool) error {
+	for _, component := range strings.Split(layout, "/") {
+		group, ok := placeholderGroups[component]
+		if !ok || groups[group] {
+			continue
+		}
+		if groups == nil {
+			return fmt.Errorf("%s can only be filled in by a regex template with the named group %s", component, group)
+		}
+		return fmt.Errorf("%s needs the named group %s in the regex", component, group)
+	}
+	return nil
+}
+
+// resolveDestination fills in the placeholders of a layout from a parsed
+// filename and returns the destination directory relative to the basepath.
+func resolveDestination(layout string, parsed *ParsedName) (string, error) {
+	values := map[string]string{
+		"{yyyy}":      parsed.Year,
+		"{mm}":        parsed.Month,
+		"{dd}":        parsed.Day,
+		"{doy}":       parsed.Doy,
+		"{hh}":        parsed.Hour,
+		"{mi}":        parsed.Minute,
+		"{satellite}": parsed.Satellite,
+		"{channel}":   parsed.Channel,
+	}
+	components := strings.Split(layout, "/")
+	for i, component := range components {
+		if _, ok := layoutPlaceholders[component]; !ok {
+			continue
+		}
+		value := values[component]
+		if value == "" || strings.ContainsAny(value, `/\`) {
+			return "", fmt.Errorf("no usable value for %s in destination %s", component, layout)
+		}
+		components[i] = value
+	}
+	return filepath.Join(components...), nil
+}
+
+// dayLayout truncates a destination layout after its last date component, so
+// that it describes the directory holding one day of data.
+func dayLayout(layout string) string {
+	components := strings.Split(layout, "/")
+	last := 0
+	for i, component := range components {
+		switch component {
+		case "{yyyy}", "{mm}", "{dd}", "{doy}":
+			last = i
+		}
+	}
+	return strings.Join(components[:last+1], "/")
+}
+
+// dayLayouts returns the distinct day layouts of all file templates.
+func (cfg *Config) dayLayouts() []string {
+	var layouts []string
+	seen := make(map[string]bool)
+	for _, template := range cfg.FileTemplates {
+		layout := dayLayout(destinationLayout(template))
+		if !seen[layout] {
+			seen[layout] = true
+			layouts = append(layouts, layout)
+		}
+	}
+	if len(layouts) == 0 {
+		layouts = append(layouts, defaultDestination)
+	}
+	return layouts
+}
+
+// dayDirectories returns the day directories below basePath for every day
+// layout in use. ModTime holds the date of the directory as YYYYMMDD and
+// Prefix the values of the non-date components of its layout.
+func (cfg *Config) dayDirectories(basePath string) ([]DirectoryInfo, error) {
+	var directories []DirectoryInfo
+	seen := make(map[string]bool)
+
+	for _, layout := range cfg.dayLayouts() {
+		components := strings.Split(layout, "/")
+		globs := make([]string, len(components))
+		for i, component := range components {
+			if glob, ok := layoutPlaceholders[component]; ok {
+				globs[i] = glob
+			} else {
+				globs[i] = component
+			}
+		}
+
+		pattern := filepath.Join(append([]string{basePath}, globs...)...)
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to glob pattern %s: %v", pattern, err)
+		}
+
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+			info, err := os.Stat(match)
+			if err != nil || !info.IsDir() {
+				continue
+			}
+			// Get the relative path from basePath; expected to follow the layout
+			relPath, err := filepath.Rel(basePath, match)
+			if err != nil {
+				continue
+			}
+			parts := strings.Split(relPath, string(os.PathSeparator))
+			if len(parts) != len(components) {
+				continue
+			}
+			dateKey, prefix, ok := parseDayPath(components, parts)
+			if !ok {
+				continue
+			}
+			seen[match] = true
+			directories = append(directories, DirectoryInfo{
+				Path:     match,
+				BasePath: basePath,
+				ModTime:  dateKey,
+				Prefix:   prefix,
+			})
+		}
+	}
+	return directories, nil
+}
+
+// formatDateKey returns a date key as its YYYYMMDD string. The leading zeros
+// of years before 1000 are lost in the integer and restored here.
+func formatDateKey(dateKey int64) string {
+	return fmt.Sprintf("%08d", dateKey)
+}
+
+// parseDayPath extracts the date key (e.g. 20220225) and the prefix from the
+// components of a day directory created with the given layout components.
+func parseDayPath(components, parts []string) (int64, string, bool) {
+	var year, month, day, doy string
+	var prefix []string
+	for i, component := range components {
+		switch component {
+		case "{yyyy}":
+			year = parts[i]
+		case "{mm}":
+			month = parts[i]
+		case "{dd}":
+			day = parts[i]
+		case "{doy}":
+			doy = parts[i]
+		case "{satellite}", "{channel}", "{hh}", "{mi}":
+			prefix = append(prefix, parts[i])
+		}
+	}
+
+	var dateStr string
+	if month != "" && day != "" {
+		dateStr = year + month + day
+		if !isValidDate(dateStr) {
+			return 0, "", false
+		}
+	} else {
+		if len(doy) != 3 || !isNumeric(year+doy) {
+			return 0, "", false
+		}
+		converted, err := convertYYYYDDDToYYYYMMDD(year + doy)
+		if err != nil {
+			return 0, "", false
+		}
+		dateStr = converted
+	}
+	if _, err := time.Parse("20060102", dateStr); err != nil {
+		return 0, "", false
+	}
+	dateKey, err := strconv.ParseInt(dateStr, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return dateKey, strings.Join(prefix, "/"), true
+}
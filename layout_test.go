@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDayPath(t *testing.T) {
+	tests := []struct {
+		layout  string
+		path    string
+		wantKey int64
+		wantPre string
+		wantOK  bool
+	}{
+		{"{yyyy}/{mm}/{dd}", "2025/03/14", 20250314, "", true},
+		{"{yyyy}/{mm}/{dd}", "0012/03/15", 120315, "", true},
+		{"{yyyy}/{mm}/{dd}", "2025/02/30", 0, "", false},
+		{"{yyyy}/{mm}/{dd}", "2025/13/01", 0, "", false},
+		{"{yyyy}/{mm}/{dd}", "abcd/03/14", 0, "", false},
+		{"{yyyy}/{doy}", "2025/073", 20250314, "", true},
+		{"{yyyy}/{doy}", "2024/366", 20241231, "", true},
+		{"{yyyy}/{doy}", "2025/73", 0, "", false},
+		{"{satellite}/{yyyy}/{mm}/{dd}", "MSG4/2025/03/14", 20250314, "MSG4", true},
+		{"data/{channel}/{yyyy}/{mm}/{dd}", "data/IR_108/2025/03/14", 20250314, "IR_108", true},
+	}
+	for _, test := range tests {
+		key, prefix, ok := parseDayPath(strings.Split(test.layout, "/"), strings.Split(test.path, "/"))
+		if ok != test.wantOK || (ok && (key != test.wantKey || prefix != test.wantPre)) {
+			t.Errorf("parseDayPath(%s, %s) = %d, %q, %v, want %d, %q, %v",
+				test.layout, test.path, key, prefix, ok, test.wantKey, test.wantPre, test.wantOK)
+		}
+	}
+}
+
+func TestFormatDateKey(t *testing.T) {
+	tests := []struct {
+		key  int64
+		want string
+	}{
+		{20250314, "20250314"},
+		{120315, "00120315"},
+		{10101, "00010101"},
+	}
+	for _, test := range tests {
+		if got := formatDateKey(test.key); got != test.want {
+			t.Errorf("formatDateKey(%d) = %s, want %s", test.key, got, test.want)
+		}
+	}
+}
+
+func TestResolveDestination(t *testing.T) {
+	parsed := &ParsedName{Year: "2025", Month: "03", Day: "14", Doy: "073", Hour: "12", Minute: "30", Satellite: "MSG4"}
+	tests := []struct {
+		layout  string
+		parsed  *ParsedName
+		want    string
+		wantErr bool
+	}{
+		{"{yyyy}/{mm}/{dd}", parsed, "2025/03/14", false},
+		{"{yyyy}/{doy}", parsed, "2025/073", false},
+		{"{satellite}/{yyyy}/{mm}/{dd}/{hh}", parsed, "MSG4/2025/03/14/12", false},
+		{"hrit/{yyyy}/{mm}/{dd}", parsed, "hrit/2025/03/14", false},
+		{"{yyyy}/{mm}/{dd}", &ParsedName{Year: "0012", Month: "03", Day: "15"}, "0012/03/15", false},
+		{"{channel}/{yyyy}/{mm}/{dd}", parsed, "", true},
+		{"{satellite}/{yyyy}/{mm}/{dd}", &ParsedName{Year: "2025", Month: "03", Day: "14", Satellite: "../x"}, "", true},
+	}
+	for _, test := range tests {
+		got, err := resolveDestination(test.layout, test.parsed)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("resolveDestination(%s) = %q, %v, want %q, error %v", test.layout, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestCompileFileTemplateLayoutValues(t *testing.T) {
+	tests := []struct {
+		template StructTemplate
+		wantErr  bool
+	}{
+		{StructTemplate{FileTemplate: "avhrr_*.bz2", StartDate: 6, DateLayout: "YYYYMMDD", Destination: "{yyyy}/{doy}"}, false},
+		{StructTemplate{FileTemplate: "avhrr_*.bz2", StartDate: 6, DateLayout: "YYYYMMDD", Destination: "{yyyy}/{mm}/{dd}/{hh}"}, true},
+		{StructTemplate{FileTemplate: "avhrr_*.bz2", StartDate: 6, DateLayout: "YYYYMMDD", Destination: "{satellite}/{yyyy}/{mm}/{dd}"}, true},
+		{StructTemplate{Regex: `^x_(?P<year>\d{4})(?P<doy>\d{3})`, Destination: "{yyyy}/{mm}/{dd}"}, false},
+		{StructTemplate{Regex: `^x_(?P<year>\d{4})(?P<doy>\d{3})(?P<hour>\d\d)`, Destination: "{yyyy}/{doy}/{hh}"}, false},
+		{StructTemplate{Regex: `^x_(?P<year>\d{4})(?P<doy>\d{3})`, Destination: "{yyyy}/{doy}/{hh}"}, true},
+		{StructTemplate{Regex: `^(?P<satellite>G\d\d)_(?P<year>\d{4})(?P<doy>\d{3})`, Destination: "{satellite}/{channel}/{yyyy}/{doy}"}, true},
+		{StructTemplate{Regex: `^(?P<satellite>G\d\d)_(?P<channel>C\d\d)_(?P<year>\d{4})(?P<doy>\d{3})`, Destination: "{satellite}/{channel}/{yyyy}/{doy}"}, false},
+	}
+	for _, test := range tests {
+		_, err := compileFileTemplate(test.template)
+		if (err != nil) != test.wantErr {
+			t.Errorf("compileFileTemplate(%+v) error = %v, want error %v", test.template, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricInfo is the help text and type of a metric in the Prometheus text
+// format.
+type metricInfo struct {
+	help string
+	kind string // counter or gauge
+}
+
+// metricInfos lists every metric exported on /metrics.
+var metricInfos = map[string]metricInfo{
+	"cleanup_files_moved_total":           {"Files filed into their destination directory.", "counter"},
+	"cleanup_bytes_moved_total":           {"Bytes of the files filed.", "counter"},
+	"cleanup_last_move_timestamp_seconds": {"Unix time a file was last filed.", "gauge"},
+	"cleanup_unmatched_deleted_total":     {"Files deleted because no file template matched.", "counter"},
+	"cleanup_unmatched_quarantined_total": {"Files quarantined because no file template matched.", "counter"},
+	"cleanup_directories_evicted_total":   {"Day directories deleted, for disk space or by the maximum retention.", "counter"},
+	"cleanup_bytes_reclaimed_total":       {"Bytes reclaimed by deleting day directories.", "counter"},
+	"cleanup_move_errors_total":           {"Files and basepaths that could not be filed.", "counter"},
+	"cleanup_stream_stalled":              {"1 while no file of a template arrived within its maximum gap.", "gauge"},
+	"cleanup_cpu_usage_percent":           {"CPU usage per core.", "gauge"},
+	"cleanup_memory_used_percent":         {"Memory in use.", "gauge"},
+	"cleanup_disk_total_bytes":            {"Size of the filesystem of a disk.", "gauge"},
+	"cleanup_disk_free_bytes":             {"Space available on a disk.", "gauge"},
+	"cleanup_disk_total_inodes":           {"Inodes of the filesystem of a disk.", "gauge"},
+	"cleanup_disk_free_inodes":            {"Free inodes on a disk.", "gauge"},
+}
+
+// metricsMutex guards metricValues.
+var metricsMutex sync.Mutex
+
+// metricValues holds the value of every series by metric name and formatted
+// labels.
+var metricValues = make(map[string]map[string]float64)
+
+// metricLabels formats label pairs, given as name, value, name, value, ...
+func metricLabels(labels []string) string {
+	var parts []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return strings.Join(parts, ",")
+}
+
+// addCounter adds value to a counter.
+func addCounter(name string, value float64, labels ...string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	series, ok := metricValues[name]
+	if !ok {
+		series = make(map[string]float64)
+		metricValues[name] = series
+	}
+	series[metricLabels(labels)] += value
+}
+
+// setGauge sets the value of a gauge.
+func setGauge(name string, value float64, labels ...string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	series, ok := metricValues[name]
+	if !ok {
+		series = make(map[string]float64)
+		metricValues[name] = series
+	}
+	series[metricLabels(labels)] = value
+}
+
+// updateDiskGauges reads the space and inodes of every configured disk.
+func updateDiskGauges() {
+	for _, thedisk := range currentConfig().Disks {
+		state, err := statDisk(thedisk.DiskName)
+		if err != nil {
+			continue
+		}
+		setGauge("cleanup_disk_total_bytes", state.TotalBytes, "disk", thedisk.DiskName)
+		setGauge("cleanup_disk_free_bytes", state.FreeBytes, "disk", thedisk.DiskName)
+		setGauge("cleanup_disk_total_inodes", state.TotalInodes, "disk", thedisk.DiskName)
+		setGauge("cleanup_disk_free_inodes", state.FreeInodes, "disk", thedisk.DiskName)
+	}
+}
+
+// writeMetrics writes every metric in the Prometheus text format.
+func writeMetrics(w io.Writer) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	names := make([]string, 0, len(metricValues))
+	for name := range metricValues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		info := metricInfos[name]
+		fmt.Fprintf(w, "# HELP %s %s\n", name, info.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, info.kind)
+
+		series := metricValues[name]
+		labels := make([]string, 0, len(series))
+		for label := range series {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			if label == "" {
+				fmt.Fprintf(w, "%s %g\n", name, series[label])
+			} else {
+				fmt.Fprintf(w, "%s{%s} %g\n", name, label, series[label])
+			}
+		}
+	}
+}
+
+// metricsHandler serves GET /metrics for Prometheus.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	updateDiskGauges()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StructNotifications configures the notifications of operational events.
+// Every notification is posted as JSON to the webhooks and mailed through the
+// SMTP relay when one is configured.
+type StructNotifications struct {
+	Webhooks []string   `yaml:"webhooks"`
+	SMTP     StructSMTP `yaml:"smtp"`
+	// Minimum seconds between two notifications of the same event, and the
+	// maximum number of notifications per hour over all events.
+	Interval   int `yaml:"interval"`
+	MaxPerHour int `yaml:"maxperhour"`
+	// Number of unmatched files deleted or quarantined in one basepath within
+	// unmatchedWindow that counts as a spike.
+	UnmatchedSpike int `yaml:"unmatchedspike"`
+}
+
+// StructSMTP configures the SMTP relay notifications are mailed through.
+type StructSMTP struct {
+	Server   string   `yaml:"server"` // host:port
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Username string   `yaml:"username"` // PLAIN authentication when set
+	Password string   `yaml:"password"`
+}
+
+// Defaults of the notifications.
+const (
+	defaultNotifyInterval   = 3600 // seconds
+	defaultNotifyMaxPerHour = 20
+	defaultUnmatchedSpike   = 100
+	unmatchedWindow         = 10 * time.Minute
+)
+
+// Notification events.
+const (
+	EventDiskFull           = "disk-full"           // No directory left to delete, the disk stays below its target
+	EventBasePathUnreadable = "basepath-unreadable" // A basepath cannot be read
+	EventUnmatchedSpike     = "unmatched-spike"     // Many unmatched files in a basepath
+	EventStreamStalled      = "stream-stalled"      // No file of a template within its maximum gap
+	EventDeleteFailed       = "delete-failed"       // A day directory could not be archived or deleted
+	EventTest               = "test"
+)
+
+// Notification is the JSON body posted to the webhooks.
+type Notification struct {
+	Event   string    `json:"event"`
+	Subject string    `json:"subject"` // Disk, basepath, template or day directory concerned
+	Message string    `json:"message"`
+	Host    string    `json:"host"`
+	Time    time.Time `json:"time"`
+	// Notifications of the same event suppressed since the previous one
+	Suppressed int `json:"suppressed"`
+	// Sent in dry-run mode, where disk states and deletions are simulated
+	DryRun bool `json:"dry_run"`
+}
+
+// notifyState is the last notification of an event for one subject.
+type notifyState struct {
+	sent       time.Time
+	suppressed int
+}
+
+// Notifier sends notifications in the background. An event is notified at
+// most once per interval for the same subject, until it is resolved.
+type Notifier struct {
+	mu        sync.Mutex
+	config    StructNotifications
+	dryRun    bool
+	host      string
+	last      map[string]*notifyState // By event and subject
+	sent      []time.Time             // Notifications sent in the last hour
+	unmatched map[string][]time.Time  // Unmatched files by basepath
+	queue     chan Notification
+}
+
+// notifier is configured at startup and on every reload. It keeps its own
+// copy of the configuration, so it never takes configMutex.
+var notifier = newNotifier()
+
+func newNotifier() *Notifier {
+	host, _ := os.Hostname()
+	return &Notifier{
+		host:      host,
+		last:      make(map[string]*notifyState),
+		unmatched: make(map[string][]time.Time),
+		queue:     make(chan Notification, 100),
+	}
+}
+
+// configure sets the configuration of the notifications, filling in the
+// defaults. In dry-run mode every notification is marked as such.
+func (n *Notifier) configure(config StructNotifications, dryRun bool) {
+	if config.Interval <= 0 {
+		config.Interval = defaultNotifyInterval
+	}
+	if config.MaxPerHour <= 0 {
+		config.MaxPerHour = defaultNotifyMaxPerHour
+	}
+	if config.UnmatchedSpike <= 0 {
+		config.UnmatchedSpike = defaultUnmatchedSpike
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.config = config
+	n.dryRun = dryRun
+}
+
+// enabled reports whether notifications have anywhere to go. The caller must
+// hold n.mu.
+func (n *Notifier) enabled() bool {
+	return len(n.config.Webhooks) > 0 || n.config.SMTP.Server != ""
+}
+
+// notify queues a notification of an event, unless the same event was
+// notified for the subject within the interval or the hourly limit is
+// reached.
+func (n *Notifier) notify(event, subject, format string, args ...interface{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.enabled() {
+		return
+	}
+
+	now := time.Now()
+	key := event + "\x00" + subject
+	state, ok := n.last[key]
+	if !ok {
+		state = &notifyState{}
+		n.last[key] = state
+	}
+	if now.Sub(state.sent) < time.Duration(n.config.Interval)*time.Second {
+		state.suppressed++
+		return
+	}
+
+	for len(n.sent) > 0 && now.Sub(n.sent[0]) >= time.Hour {
+		n.sent = n.sent[1:]
+	}
+	if len(n.sent) >= n.config.MaxPerHour {
+		fmt.Printf("Notification limit of %d per hour reached, dropping %s %s\n", n.config.MaxPerHour, event, subject)
+		state.suppressed++
+		return
+	}
+
+	notification := Notification{
+		Event:      event,
+		Subject:    subject,
+		Message:    fmt.Sprintf(format, args...),
+		Host:       n.host,
+		Time:       now.UTC(),
+		Suppressed: state.suppressed,
+		DryRun:     n.dryRun,
+	}
+	select {
+	case n.queue <- notification:
+		state.sent, state.suppressed = now, 0
+		n.sent = append(n.sent, now)
+	default:
+		fmt.Printf("Notification queue full, dropping %s %s\n", event, subject)
+		state.suppressed++
+	}
+}
+
+// resolve forgets an event of a subject after its cause went away, so that
+// it is notified again as soon as it recurs.
+func (n *Notifier) resolve(event, subject string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.last, event+"\x00"+subject)
+}
+
+// noteUnmatched counts an unmatched file in a basepath and notifies a spike
+// when too many arrive within unmatchedWindow.
+func (n *Notifier) noteUnmatched(basepath string) {
+	n.mu.Lock()
+	now := time.Now()
+	times := append(n.unmatched[basepath], now)
+	for len(times) > 0 && now.Sub(times[0]) > unmatchedWindow {
+		times = times[1:]
+	}
+	n.unmatched[basepath] = times
+	count, spike := len(times), n.config.UnmatchedSpike
+	n.mu.Unlock()
+
+	if count >= spike {
+		n.notify(EventUnmatchedSpike, basepath, "%d unmatched files deleted or quarantined in %s within %v", count, basepath, unmatchedWindow)
+	}
+}
+
+// run sends the queued notifications until done is closed.
+func (n *Notifier) run(done chan bool) {
+	for {
+		select {
+		case <-done:
+			return
+		case notification := <-n.queue:
+			fmt.Printf("Notification: %s %s: %s\n", notification.Event, notification.Subject, notification.Message)
+			if err := n.deliver(notification); err != nil {
+				fmt.Printf("Error sending notification: %v\n", err)
+			}
+		}
+	}
+}
+
+// deliver posts a notification to every webhook and mails it.
+func (n *Notifier) deliver(notification Notification) error {
+	n.mu.Lock()
+	config := n.config
+	n.mu.Unlock()
+
+	var errs []error
+	for _, webhook := range config.Webhooks {
+		if err := postWebhook(webhook, notification); err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s: %v", webhook, err))
+		}
+	}
+	if config.SMTP.Server != "" {
+		if err := sendMail(config.SMTP, notification); err != nil {
+			errs = append(errs, fmt.Errorf("mail via %s: %v", config.SMTP.Server, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// smtpTimeout bounds the delivery of one mail, so that a relay that hangs
+// does not hold up the notifications after it.
+const smtpTimeout = 10 * time.Second
+
+// postWebhook posts a notification as JSON.
+func postWebhook(url string, notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// sendMail mails a notification through the SMTP relay. Like smtp.SendMail
+// it uses STARTTLS when the relay offers it, but within smtpTimeout.
+func sendMail(config StructSMTP, notification Notification) error {
+	host, _, _ := net.SplitHostPort(config.Server)
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(config.To, ", "))
+	tag := "cleanup " + notification.Host
+	if notification.DryRun {
+		tag += " dry-run"
+	}
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.TrimSpace(fmt.Sprintf("[%s] %s %s", tag, notification.Event, notification.Subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", notification.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n", notification.Message)
+	if notification.DryRun {
+		fmt.Fprintf(&msg, "\r\nSent in dry-run mode: nothing was moved or deleted, the state described may be simulated.\r\n")
+	}
+	if notification.Suppressed > 0 {
+		fmt.Fprintf(&msg, "\r\n%d earlier notification(s) of this event were suppressed.\r\n", notification.Suppressed)
+	}
+
+	conn, err := net.DialTimeout("tcp", config.Server, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", config.Username, config.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(config.From); err != nil {
+		return err
+	}
+	for _, to := range config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg.String())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// runTestNotify implements the test-notify command: it sends a test
+// notification to every webhook and the SMTP relay and returns the exit
+// status.
+func runTestNotify() int {
+	config, _, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if len(config.Notifications.Webhooks) == 0 && config.Notifications.SMTP.Server == "" {
+		fmt.Println("Error: no webhooks or smtp server configured")
+		return 1
+	}
+	notifier.configure(config.Notifications, config.DryRun)
+	err = notifier.deliver(Notification{
+		Event:   EventTest,
+		Message: "Test notification",
+		Host:    notifier.host,
+		Time:    time.Now().UTC(),
+		DryRun:  config.DryRun,
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	fmt.Println("Test notification sent")
+	return 0
+}
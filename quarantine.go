@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// quarantineSuffix is appended to the name of a quarantined file to form the
+// name of its sidecar record.
+const quarantineSuffix = ".rejected.json"
+
+// QuarantineRecord is written next to every quarantined file and explains why
+// the file was rejected.
+type QuarantineRecord struct {
+	File     string    `json:"file"`
+	BasePath string    `json:"basepath"`
+	Reason   string    `json:"reason"`
+	Time     time.Time `json:"time"`
+}
+
+// quarantineFor returns the quarantine settings for a basepath. Settings on
+// the basepath take precedence over the global ones.
+func (cfg *Config) quarantineFor(bp StructBasePath) StructQuarantine {
+	if bp.Quarantine != nil {
+		return *bp.Quarantine
+	}
+	return cfg.Quarantine
+}
+
+// quarantineFile moves an unmatched file into the quarantine directory, keeping
+// its original name, writes a sidecar record with the reason and returns the
+// path of the quarantined file. When a file of that name is already
+// quarantined, the incoming file gets a unique name so that neither the
+// earlier file nor its record is overwritten.
+func quarantineFile(quarantineDir, basepath, filename, reason string) (string, error) {
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory %s: %v", quarantineDir, err)
+	}
+
+	fullPath := filepath.Join(basepath, filename)
+	newPath := filepath.Join(quarantineDir, filename)
+	if _, err := os.Lstat(newPath); err == nil {
+		newPath = uniquePath(newPath)
+		fmt.Printf("Quarantine %s: file already quarantined, incoming file renamed to %s\n", quarantineDir, filepath.Base(newPath))
+	}
+	if err := renameFile(fullPath, newPath, false); err != nil {
+		return "", fmt.Errorf("failed to quarantine %s to %s: %v", fullPath, newPath, err)
+	}
+
+	record := QuarantineRecord{
+		File:     filename,
+		BasePath: basepath,
+		Reason:   reason,
+		Time:     time.Now().UTC(),
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return newPath, fmt.Errorf("failed to marshal quarantine record for %s: %v", filename, err)
+	}
+	if err := os.WriteFile(newPath+quarantineSuffix, data, 0644); err != nil {
+		return newPath, fmt.Errorf("failed to write quarantine record for %s: %v", newPath, err)
+	}
+	return newPath, nil
+}
+
+// purgeQuarantine deletes quarantined files, together with their sidecar
+// records, once they are older than the configured retention.
+func purgeQuarantine() error {
+	cfg := currentConfig()
+
+	quarantines := []StructQuarantine{cfg.Quarantine}
+	for _, bp := range cfg.BasePaths {
+		if bp.Quarantine != nil {
+			quarantines = append(quarantines, *bp.Quarantine)
+		}
+	}
+
+	for _, quarantine := range quarantines {
+		if quarantine.Directory == "" || quarantine.RetentionDays <= 0 {
+			continue
+		}
+		cutoff := time.Now().AddDate(0, 0, -quarantine.RetentionDays)
+
+		entries, err := os.ReadDir(quarantine.Directory)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read quarantine directory %s: %v", quarantine.Directory, err)
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), quarantineSuffix) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+
+			sidecar := filepath.Join(quarantine.Directory, entry.Name())
+			quarantined := strings.TrimSuffix(sidecar, quarantineSuffix)
+			if cfg.DryRun {
+				fmt.Printf("Dry-run: would purge quarantined file %s\n", quarantined)
+				continue
+			}
+			var size int64
+			if quarantinedInfo, err := os.Stat(quarantined); err == nil {
+				size = quarantinedInfo.Size()
+			}
+			err = os.Remove(quarantined)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+			auditLog.record(AuditEntry{Action: AuditPurge, Source: quarantined, Size: size,
+				Rule: fmt.Sprintf("quarantine retentiondays %d", quarantine.RetentionDays)}, err)
+			if err != nil {
+				fmt.Printf("Warning: failed to purge quarantined file %s: %v\n", quarantined, err)
+				continue
+			}
+			os.Remove(sidecar)
+			fmt.Printf("Purged quarantined file: %s\n", quarantined)
+		}
+	}
+	return nil
+}
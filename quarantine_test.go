@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuarantineFileKeepsEarlierFile(t *testing.T) {
+	basepath, quarantineDir := t.TempDir(), t.TempDir()
+	want := []string{filepath.Join(quarantineDir, "x.dat.bz2"), filepath.Join(quarantineDir, "x_1.dat.bz2")}
+	for i, reason := range []string{"first", "second"} {
+		writeFiles(t, basepath, map[string]int{"x.dat.bz2": i + 1})
+		got, err := quarantineFile(quarantineDir, basepath, "x.dat.bz2", reason)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want[i] {
+			t.Errorf("quarantineFile #%d = %s, want %s", i+1, got, want[i])
+		}
+	}
+	for i, path := range want {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(i+1) {
+			t.Errorf("%s has %d bytes, want %d", path, info.Size(), i+1)
+		}
+		data, err := os.ReadFile(path + quarantineSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var record QuarantineRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			t.Fatal(err)
+		}
+		if wantReason := []string{"first", "second"}[i]; record.Reason != wantReason {
+			t.Errorf("record of %s has reason %q, want %q", path, record.Reason, wantReason)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// StructRetention limits how long the day directories of a basepath are kept.
+// Directories more than MaxDays days old are deleted on schedule, whatever the
+// free space. Directories at most MinDays days old are never deleted to free
+// space. Forever keeps every directory. A zero value means no limit.
+type StructRetention struct {
+	MaxDays int  `yaml:"maxdays"`
+	MinDays int  `yaml:"mindays"`
+	Forever bool `yaml:"forever"`
+}
+
+// expired reports whether a day directory is older than the maximum
+// retention.
+func (r StructRetention) expired(dateKey int64, now time.Time) bool {
+	if r.Forever || r.MaxDays <= 0 {
+		return false
+	}
+	age, ok := dayAge(dateKey, now)
+	return ok && age > r.MaxDays
+}
+
+// protected reports whether a day directory may not be deleted to free disk
+// space.
+func (r StructRetention) protected(dateKey int64, now time.Time) bool {
+	if r.Forever {
+		return true
+	}
+	if r.MinDays <= 0 {
+		return false
+	}
+	age, ok := dayAge(dateKey, now)
+	return !ok || age <= r.MinDays
+}
+
+// dayAge returns the number of days between a YYYYMMDD date key and the day
+// of now. Today has age 0.
+func dayAge(dateKey int64, now time.Time) (int, bool) {
+	date, err := time.Parse("20060102", formatDateKey(dateKey))
+	if err != nil {
+		return 0, false
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	// A time.Duration only spans about 292 years, so count in seconds.
+	return int((today.Unix() - date.Unix()) / (24 * 60 * 60)), true
+}
+
+// retentionFor returns the retention of the basepaths filed below a
+// destination root. When several basepaths share a root, the most
+// conservative rule wins.
+func (cfg *Config) retentionFor(root string) StructRetention {
+	var retention StructRetention
+	first := true
+	for _, bp := range cfg.BasePaths {
+		if bp.destinationRoot() != root {
+			continue
+		}
+		r := bp.Retention
+		if first {
+			retention, first = r, false
+			continue
+		}
+		retention.Forever = retention.Forever || r.Forever
+		retention.MinDays = max(retention.MinDays, r.MinDays)
+		if retention.MaxDays > 0 && (r.MaxDays <= 0 || r.MaxDays > retention.MaxDays) {
+			retention.MaxDays = r.MaxDays
+		}
+	}
+	return retention
+}
+
+// deleteExpiredDirectories deletes the day directories that are older than
+// the maximum retention of their basepath.
+func deleteExpiredDirectories() error {
+	cfg := currentConfig()
+
+	var report dryRunReport
+	if cfg.DryRun {
+		defer report.print("deleteExpiredDirectories")
+	}
+
+	now := time.Now()
+	removed := make(map[string]bool)
+	done := make(map[string]bool)
+	for _, bp := range cfg.BasePaths {
+		root := bp.destinationRoot()
+		if done[root] {
+			continue
+		}
+		done[root] = true
+
+		retention := cfg.retentionFor(root)
+		if retention.Forever || retention.MaxDays <= 0 {
+			continue
+		}
+
+		directories, err := cfg.dayDirectories(root)
+		if err != nil {
+			return err
+		}
+		for _, dir := range directories {
+			if !retention.expired(dir.ModTime, now) {
+				continue
+			}
+			if cfg.DryRun {
+				if archiveDir := cfg.archiveFor(root); archiveDir != "" {
+					report.add("archive", fmt.Sprintf("%s to %s", dir.Path, archiveDir))
+				}
+				report.add("delete expired", dir.Path)
+				removed[dir.Path] = true
+				for _, pruned := range simulateCleanUpEmptyAncestors(dir.Path, dir.BasePath, removed) {
+					report.add("prune", pruned)
+				}
+				continue
+			}
+			fmt.Printf("Deleting expired directory (older than %d days): %s\n", retention.MaxDays, dir.Path)
+			size, _ := dirSize(dir.Path)
+			if err := removeDay(cfg, dir, size, fmt.Sprintf("retention maxdays %d", retention.MaxDays), ""); err != nil {
+				fmt.Printf("Error: %v, skipping it\n", err)
+				notifier.notify(EventDeleteFailed, dir.Path, "Expired day directory not deleted: %v", err)
+				continue
+			}
+			notifier.resolve(EventDeleteFailed, dir.Path)
+			recordReclaim(dir.BasePath, size, true)
+			cleanUpEmptyAncestors(dir.Path, dir.BasePath)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDayAge(t *testing.T) {
+	now := time.Date(2025, 3, 14, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		key    int64
+		want   int
+		wantOK bool
+	}{
+		{20250314, 0, true},
+		{20250313, 1, true},
+		{20240314, 365, true},
+		{120315, 735232, true},
+		{20250230, 0, false},
+	}
+	for _, test := range tests {
+		age, ok := dayAge(test.key, now)
+		if ok != test.wantOK || (ok && age != test.want) {
+			t.Errorf("dayAge(%d) = %d, %v, want %d, %v", test.key, age, ok, test.want, test.wantOK)
+		}
+	}
+
+	retention := StructRetention{MaxDays: 30, MinDays: 7}
+	if !retention.expired(120315, now) {
+		t.Errorf("directory of year 12 not expired with maxdays 30")
+	}
+	if retention.protected(120315, now) {
+		t.Errorf("directory of year 12 protected with mindays 7")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// runTestTemplates implements the test-templates command. It reads filenames,
+// one per line, from the named file or from stdin when the name is empty or
+// "-", and prints what would happen to each of them. It returns the exit
+// status.
+func runTestTemplates(namesFile string) int {
+	config, patterns, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	cfg := &Config{YAMLConfig: config, Patterns: patterns}
+
+	var input io.Reader = os.Stdin
+	if namesFile != "" && namesFile != "-" {
+		f, err := os.Open(namesFile)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		input = f
+	}
+
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		filename := strings.TrimSpace(scanner.Text())
+		if filename == "" {
+			continue
+		}
+		testTemplates(os.Stdout, cfg, filename)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Error reading filenames: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// testTemplates prints which template a filename matches first, the date and
+// destination that result from it, and the other templates that also match.
+func testTemplates(w io.Writer, cfg *Config, filename string) {
+	fmt.Fprintln(w, filename)
+
+	var matches []int
+	for i, re := range cfg.Patterns {
+		if re.MatchString(filename) {
+			matches = append(matches, i)
+		}
+	}
+
+	parsed, reason, err := cfg.parseFilename(filename)
+	switch {
+	case err != nil:
+		fmt.Fprintf(w, "  template:     %s\n", describeTemplate(cfg, matches[0]))
+		fmt.Fprintf(w, "  skipped:      %v, the file would be left in place\n", err)
+	case parsed == nil:
+		actions, basepaths := unmatchedActions(cfg)
+		if len(actions) == 1 {
+			fmt.Fprintf(w, "  unmatched:    %s, the file would be %s\n", reason, actions[0])
+			break
+		}
+		fmt.Fprintf(w, "  unmatched:    %s, the file would be\n", reason)
+		for i, action := range actions {
+			fmt.Fprintf(w, "                %s from %s\n", action, strings.Join(basepaths[i], ", "))
+		}
+	default:
+		fmt.Fprintf(w, "  template:     %s\n", describeTemplate(cfg, parsed.Template))
+		fmt.Fprintf(w, "  date:         %s\n", parsed.Date)
+		destination, err := resolveDestination(destinationLayout(cfg.FileTemplates[parsed.Template]), parsed)
+		if err != nil {
+			fmt.Fprintf(w, "  skipped:      %v, the file would be left in place\n", err)
+		} else {
+			fmt.Fprintf(w, "  destination:  %s\n", destination)
+		}
+	}
+
+	// First match wins, so any later match is shadowed by the first one.
+	for _, i := range matches[min(1, len(matches)):] {
+		fmt.Fprintf(w, "  also matches: %s\n", describeTemplate(cfg, i))
+	}
+}
+
+// unmatchedActions returns what happens to an unmatched file, deletion or
+// quarantine, and for each action the basepaths it applies to. Basepaths can
+// override the global quarantine setting, see quarantineFor.
+func unmatchedActions(cfg *Config) ([]string, [][]string) {
+	var actions []string
+	var basepaths [][]string
+	add := func(quarantine StructQuarantine, basepath string) {
+		action := "deleted"
+		if quarantine.Directory != "" {
+			action = "quarantined in " + quarantine.Directory
+		}
+		for i := range actions {
+			if actions[i] == action {
+				basepaths[i] = append(basepaths[i], basepath)
+				return
+			}
+		}
+		actions = append(actions, action)
+		basepaths = append(basepaths, []string{basepath})
+	}
+	for _, bp := range cfg.BasePaths {
+		add(cfg.quarantineFor(bp), bp.Path)
+	}
+	if len(actions) == 0 {
+		add(cfg.Quarantine, "")
+	}
+	return actions, basepaths
+}
+
+// describeTemplate returns the number and pattern of a file template.
+func describeTemplate(cfg *Config, i int) string {
+	template := cfg.FileTemplates[i]
+	if template.Regex != "" {
+		return fmt.Sprintf("%d regex %s", i+1, template.Regex)
+	}
+	return fmt.Sprintf("%d %s (startdate %d, %s)", i+1, template.FileTemplate, template.StartDate, template.DateLayout)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTestTemplatesUnmatched(t *testing.T) {
+	tests := []struct {
+		global    StructQuarantine
+		basepaths []StructBasePath
+		want      string
+	}{
+		{
+			StructQuarantine{},
+			[]StructBasePath{{Path: "/data/a"}, {Path: "/data/b"}},
+			"  unmatched:    no file template matched, the file would be deleted\n",
+		},
+		{
+			StructQuarantine{Directory: "/quarantine"},
+			[]StructBasePath{{Path: "/data/a"}},
+			"  unmatched:    no file template matched, the file would be quarantined in /quarantine\n",
+		},
+		{
+			StructQuarantine{Directory: "/quarantine"},
+			[]StructBasePath{{Path: "/data/a", Quarantine: &StructQuarantine{}}},
+			"  unmatched:    no file template matched, the file would be deleted\n",
+		},
+		{
+			StructQuarantine{},
+			[]StructBasePath{
+				{Path: "/data/a"},
+				{Path: "/data/b", Quarantine: &StructQuarantine{Directory: "/quarantine/b"}},
+				{Path: "/data/c"},
+			},
+			"  unmatched:    no file template matched, the file would be\n" +
+				"                deleted from /data/a, /data/c\n" +
+				"                quarantined in /quarantine/b from /data/b\n",
+		},
+	}
+	for i, test := range tests {
+		cfg := &Config{YAMLConfig: YAMLConfig{BasePaths: test.basepaths, Quarantine: test.global}}
+		var out strings.Builder
+		testTemplates(&out, cfg, "unknown.nc")
+		got := strings.TrimPrefix(out.String(), "unknown.nc\n")
+		if got != test.want {
+			t.Errorf("%d: testTemplates =\n%swant\n%s", i, got, test.want)
+		}
+	}
+}
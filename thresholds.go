@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// threshold is an amount of free space or free inodes, either absolute or a
+// percentage of the total. The zero threshold is not set.
+type threshold struct {
+	Value   float64
+	Percent bool
+}
+
+// byteUnits are the units accepted in byte thresholds.
+var byteUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// inodeUnits are the units accepted in inode thresholds.
+var inodeUnits = map[string]float64{
+	"":  1,
+	"K": 1e3,
+	"M": 1e6,
+}
+
+// parseThreshold parses a threshold like "15%", "500GiB" or "2M". The units
+// are byteUnits or inodeUnits.
+func parseThreshold(s string, units map[string]float64) (threshold, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return threshold{}, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+		if err != nil || value < 0 || value > 100 {
+			return threshold{}, fmt.Errorf("invalid percentage %q", s)
+		}
+		return threshold{Value: value, Percent: true}, nil
+	}
+
+	number := strings.TrimRight(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	unit, ok := units[strings.ToUpper(strings.TrimSpace(s[len(number):]))]
+	if !ok {
+		return threshold{}, fmt.Errorf("unknown unit in %q", s)
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value < 0 {
+		return threshold{}, fmt.Errorf("invalid amount %q", s)
+	}
+	return threshold{Value: value * unit}, nil
+}
+
+// set reports whether the threshold is configured.
+func (t threshold) set() bool {
+	return t.Value > 0
+}
+
+// of returns the threshold as an absolute amount of a total.
+func (t threshold) of(total float64) float64 {
+	if t.Percent {
+		return total * t.Value / 100
+	}
+	return t.Value
+}
+
+// diskLimits are the thresholds of a disk. Cleanup starts when the free space
+// or the free inodes drop below their minimum, the high-water mark of the
+// disk usage, and goes on until both are at least their target, the
+// low-water mark.
+type diskLimits struct {
+	minFree, targetFree     threshold
+	minInodes, targetInodes threshold
+}
+
+// limitsFor returns the thresholds of a disk. The percentage freediskspace is
+// both minimum and target. A target defaults to its minimum.
+func limitsFor(thedisk StructDisks) (diskLimits, error) {
+	var limits diskLimits
+	var err error
+	if limits.minFree, err = parseThreshold(thedisk.MinFree, byteUnits); err != nil {
+		return limits, fmt.Errorf("minfree: %v", err)
+	}
+	if limits.targetFree, err = parseThreshold(thedisk.TargetFree, byteUnits); err != nil {
+		return limits, fmt.Errorf("targetfree: %v", err)
+	}
+	if limits.minInodes, err = parseThreshold(thedisk.MinFreeInodes, inodeUnits); err != nil {
+		return limits, fmt.Errorf("minfreeinodes: %v", err)
+	}
+	if limits.targetInodes, err = parseThreshold(thedisk.TargetFreeInodes, inodeUnits); err != nil {
+		return limits, fmt.Errorf("targetfreeinodes: %v", err)
+	}
+
+	if !limits.minFree.set() && thedisk.FreeDiskSpace > 0 {
+		limits.minFree = threshold{Value: float64(thedisk.FreeDiskSpace), Percent: true}
+	}
+	if !limits.targetFree.set() {
+		limits.targetFree = limits.minFree
+	}
+	if !limits.targetInodes.set() {
+		limits.targetInodes = limits.minInodes
+	}
+	return limits, nil
+}
+
+// diskState is the space and inode usage of a filesystem.
+type diskState struct {
+	TotalBytes, FreeBytes   float64
+	TotalInodes, FreeInodes float64
+}
+
+// statDisk returns the space and inodes available to unprivileged users.
+func statDisk(diskdir string) (diskState, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(diskdir, &stat); err != nil {
+		return diskState{}, err
+	}
+	return diskState{
+		TotalBytes:  float64(stat.Blocks) * float64(stat.Bsize),
+		FreeBytes:   float64(stat.Bavail) * float64(stat.Bsize),
+		TotalInodes: float64(stat.Files),
+		FreeInodes:  float64(stat.Ffree),
+	}, nil
+}
+
+// needsCleanup reports whether the free space or free inodes are below their
+// minimum. Filesystems that do not report inodes are only checked on space.
+func (l diskLimits) needsCleanup(s diskState) bool {
+	if l.minFree.set() && s.FreeBytes < l.minFree.of(s.TotalBytes) {
+		return true
+	}
+	return l.minInodes.set() && s.TotalInodes > 0 && s.FreeInodes < l.minInodes.of(s.TotalInodes)
+}
+
+// reached reports whether the free space and free inodes are at their
+// targets.
+func (l diskLimits) reached(s diskState) bool {
+	if l.targetFree.set() && s.FreeBytes < l.targetFree.of(s.TotalBytes) {
+		return false
+	}
+	return !l.targetInodes.set() || s.TotalInodes == 0 || s.FreeInodes >= l.targetInodes.of(s.TotalInodes)
+}
+
+func (l diskLimits) String() string {
+	var parts []string
+	if l.minFree.set() {
+		parts = append(parts, fmt.Sprintf("space min %s target %s", formatThreshold(l.minFree, true), formatThreshold(l.targetFree, true)))
+	}
+	if l.minInodes.set() {
+		parts = append(parts, fmt.Sprintf("inodes min %s target %s", formatThreshold(l.minInodes, false), formatThreshold(l.targetInodes, false)))
+	}
+	if len(parts) == 0 {
+		return "no thresholds"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s diskState) String() string {
+	text := fmt.Sprintf("free space %.2f GiB (%.2f%%)", s.FreeBytes/(1<<30), percentOf(s.FreeBytes, s.TotalBytes))
+	if s.TotalInodes > 0 {
+		text += fmt.Sprintf(", free inodes %.0f (%.2f%%)", s.FreeInodes, percentOf(s.FreeInodes, s.TotalInodes))
+	}
+	return text
+}
+
+// formatThreshold formats a threshold of bytes in GiB and of inodes as a
+// count.
+func formatThreshold(t threshold, bytes bool) string {
+	switch {
+	case t.Percent:
+		return fmt.Sprintf("%g%%", t.Value)
+	case bytes:
+		return fmt.Sprintf("%.2f GiB", t.Value/(1<<30))
+	}
+	return fmt.Sprintf("%.0f", t.Value)
+}
+
+func percentOf(value, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return value / total * 100
+}
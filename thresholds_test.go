@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		s       string
+		units   map[string]float64
+		want    threshold
+		wantErr bool
+	}{
+		{"", byteUnits, threshold{}, false},
+		{"15%", byteUnits, threshold{Value: 15, Percent: true}, false},
+		{" 2.5 % ", inodeUnits, threshold{Value: 2.5, Percent: true}, false},
+		{"0%", byteUnits, threshold{Value: 0, Percent: true}, false},
+		{"100%", byteUnits, threshold{Value: 100, Percent: true}, false},
+		{"101%", byteUnits, threshold{}, true},
+		{"-1%", byteUnits, threshold{}, true},
+		{"x%", byteUnits, threshold{}, true},
+		{"1024", byteUnits, threshold{Value: 1024}, false},
+		{"10B", byteUnits, threshold{Value: 10}, false},
+		{"500GB", byteUnits, threshold{Value: 500e9}, false},
+		{"500GiB", byteUnits, threshold{Value: 500 << 30}, false},
+		{"1.5 tib", byteUnits, threshold{Value: 1.5 * (1 << 40)}, false},
+		{"2kib", byteUnits, threshold{Value: 2048}, false},
+		{"2M", inodeUnits, threshold{Value: 2e6}, false},
+		{"300k", inodeUnits, threshold{Value: 300e3}, false},
+		{"2M", byteUnits, threshold{}, true},
+		{"2GB", inodeUnits, threshold{}, true},
+		{"GB", byteUnits, threshold{}, true},
+		{"-5GB", byteUnits, threshold{}, true},
+		{"1.2.3GB", byteUnits, threshold{}, true},
+	}
+	for _, test := range tests {
+		got, err := parseThreshold(test.s, test.units)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseThreshold(%q) error = %v, want error %v", test.s, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseThreshold(%q) = %+v, want %+v", test.s, got, test.want)
+		}
+	}
+}
+
+func TestLimitsFor(t *testing.T) {
+	tests := []struct {
+		disk    StructDisks
+		want    diskLimits
+		wantErr bool
+	}{
+		{StructDisks{}, diskLimits{}, false},
+		{
+			StructDisks{FreeDiskSpace: 20},
+			diskLimits{minFree: threshold{20, true}, targetFree: threshold{20, true}},
+			false,
+		},
+		{
+			StructDisks{FreeDiskSpace: 20, MinFree: "10GiB"},
+			diskLimits{minFree: threshold{10 << 30, false}, targetFree: threshold{10 << 30, false}},
+			false,
+		},
+		{
+			StructDisks{MinFree: "10%", TargetFree: "25%"},
+			diskLimits{minFree: threshold{10, true}, targetFree: threshold{25, true}},
+			false,
+		},
+		{
+			StructDisks{FreeDiskSpace: 10, TargetFree: "1TB"},
+			diskLimits{minFree: threshold{10, true}, targetFree: threshold{1e12, false}},
+			false,
+		},
+		{
+			StructDisks{MinFreeInodes: "5%"},
+			diskLimits{minInodes: threshold{5, true}, targetInodes: threshold{5, true}},
+			false,
+		},
+		{
+			StructDisks{MinFreeInodes: "100k", TargetFreeInodes: "1M"},
+			diskLimits{minInodes: threshold{100e3, false}, targetInodes: threshold{1e6, false}},
+			false,
+		},
+		{StructDisks{MinFree: "10XB"}, diskLimits{}, true},
+		{StructDisks{TargetFree: "200%"}, diskLimits{}, true},
+		{StructDisks{MinFreeInodes: "1GiB"}, diskLimits{}, true},
+		{StructDisks{TargetFreeInodes: "many"}, diskLimits{}, true},
+	}
+	for _, test := range tests {
+		got, err := limitsFor(test.disk)
+		if (err != nil) != test.wantErr {
+			t.Errorf("limitsFor(%+v) error = %v, want error %v", test.disk, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && got != test.want {
+			t.Errorf("limitsFor(%+v) = %+v, want %+v", test.disk, got, test.want)
+		}
+	}
+}
+
+func TestDiskLimitsWatermarks(t *testing.T) {
+	limits, err := limitsFor(StructDisks{MinFree: "10%", TargetFree: "20%", MinFreeInodes: "1k", TargetFreeInodes: "2k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		state       diskState
+		wantCleanup bool
+		wantReached bool
+	}{
+		// Above both targets.
+		{diskState{TotalBytes: 100, FreeBytes: 30, TotalInodes: 1e6, FreeInodes: 5000}, false, true},
+		// Between minimum and target: no cleanup starts, but one going on
+		// continues.
+		{diskState{TotalBytes: 100, FreeBytes: 15, TotalInodes: 1e6, FreeInodes: 5000}, false, false},
+		{diskState{TotalBytes: 100, FreeBytes: 30, TotalInodes: 1e6, FreeInodes: 1500}, false, false},
+		// Below a minimum.
+		{diskState{TotalBytes: 100, FreeBytes: 5, TotalInodes: 1e6, FreeInodes: 5000}, true, false},
+		{diskState{TotalBytes: 100, FreeBytes: 30, TotalInodes: 1e6, FreeInodes: 500}, true, false},
+		// A filesystem without inodes is only checked on space.
+		{diskState{TotalBytes: 100, FreeBytes: 30}, false, true},
+	}
+	for _, test := range tests {
+		if got := limits.needsCleanup(test.state); got != test.wantCleanup {
+			t.Errorf("needsCleanup(%v) = %v, want %v", test.state, got, test.wantCleanup)
+		}
+		if got := limits.reached(test.state); got != test.wantReached {
+			t.Errorf("reached(%v) = %v, want %v", test.state, got, test.wantReached)
+		}
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	tests := []struct {
+		minimum, target threshold
+		want            string
+	}{
+		{threshold{10, true}, threshold{20, true}, ""},
+		{threshold{10, true}, threshold{10, true}, ""},
+		{threshold{20, true}, threshold{10, true}, "target for free space is below its minimum"},
+		{threshold{2e9, false}, threshold{1e9, false}, "target for free space is below its minimum"},
+		{threshold{50, true}, threshold{1e9, false}, ""},
+		{threshold{}, threshold{10, true}, "a target for free space needs a minimum"},
+		{threshold{}, threshold{}, ""},
+	}
+	for _, test := range tests {
+		var got string
+		checkLimits(1, "/data", "free space", test.minimum, test.target, func(line int, warning bool, format string, args ...any) {
+			got = fmt.Sprintf(format, args...)
+		})
+		if !strings.HasSuffix(got, test.want) || (test.want == "") != (got == "") {
+			t.Errorf("checkLimits(%+v, %+v) = %q, want %q", test.minimum, test.target, got, test.want)
+		}
+	}
+}
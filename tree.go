@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BasePathTree is the data below the destination root of a basepath, by year,
+// month and day. A day counts every directory of that date, one per satellite
+// or channel for layouts that have them.
+type BasePathTree struct {
+	BasePath string     `json:"basepath"`
+	Files    int64      `json:"files"`
+	Bytes    int64      `json:"bytes"`
+	Years    []YearTree `json:"years"`
+}
+
+// YearTree is the data of one year.
+type YearTree struct {
+	Year   string      `json:"year"` // YYYY
+	Files  int64       `json:"files"`
+	Bytes  int64       `json:"bytes"`
+	Months []MonthTree `json:"months"`
+}
+
+// MonthTree is the data of one month.
+type MonthTree struct {
+	Month string    `json:"month"` // MM
+	Files int64     `json:"files"`
+	Bytes int64     `json:"bytes"`
+	Days  []DayTree `json:"days"`
+}
+
+// DayTree is the data of one day.
+type DayTree struct {
+	Day   string `json:"day"` // DD
+	Files int64  `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// dayUsage is the usage of a day directory together with the modification
+// time of every directory in it, the day directory included.
+type dayUsage struct {
+	usage    DirUsage
+	modTimes map[string]time.Time
+}
+
+// dayUsageCache holds the usage of every day directory seen by buildTree, by
+// path. Files come and go by renaming, which changes the modification time of
+// their directory, so a cached day is only walked again when one of its
+// directories changed.
+var (
+	dayUsageMutex sync.Mutex
+	dayUsageCache = make(map[string]*dayUsage)
+)
+
+// walkDay returns the usage of a day directory.
+func walkDay(dir string) (*dayUsage, error) {
+	day := &dayUsage{modTimes: make(map[string]time.Time)}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		day.usage.Inodes++
+		if d.IsDir() {
+			day.modTimes[path] = info.ModTime()
+			return nil
+		}
+		day.usage.Bytes += info.Size()
+		day.usage.Files++
+		return nil
+	})
+	return day, err
+}
+
+// changed reports whether a directory of the day was modified, added or
+// removed since it was walked.
+func (d *dayUsage) changed() bool {
+	for path, modTime := range d.modTimes {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// cachedDayUsage returns the usage of a day directory, from the cache when
+// the day did not change. A day that cannot be walked is not cached.
+func cachedDayUsage(dir string) DirUsage {
+	dayUsageMutex.Lock()
+	day, ok := dayUsageCache[dir]
+	dayUsageMutex.Unlock()
+	if ok && !day.changed() {
+		return day.usage
+	}
+
+	day, err := walkDay(dir)
+	dayUsageMutex.Lock()
+	defer dayUsageMutex.Unlock()
+	if err != nil {
+		delete(dayUsageCache, dir)
+	} else {
+		dayUsageCache[dir] = day
+	}
+	return day.usage
+}
+
+// forgetDays drops the cached days below root that are not in directories.
+func forgetDays(root string, directories []DirectoryInfo) {
+	current := make(map[string]bool, len(directories))
+	for _, dir := range directories {
+		current[dir.Path] = true
+	}
+	prefix := filepath.Clean(root) + string(filepath.Separator)
+	dayUsageMutex.Lock()
+	defer dayUsageMutex.Unlock()
+	for path := range dayUsageCache {
+		if strings.HasPrefix(path, prefix) && !current[path] {
+			delete(dayUsageCache, path)
+		}
+	}
+}
+
+// buildTree returns the tree of the day directories below root. A day
+// directory that cannot be read counts as empty.
+func buildTree(cfg *Config, root string) (BasePathTree, error) {
+	tree := BasePathTree{BasePath: root, Years: []YearTree{}}
+	directories, err := cfg.dayDirectories(root)
+	if err != nil {
+		return tree, err
+	}
+	forgetDays(root, directories)
+
+	byDate := make(map[int64]DirUsage)
+	for _, dir := range directories {
+		usage := cachedDayUsage(dir.Path)
+		total := byDate[dir.ModTime]
+		total.Files += usage.Files
+		total.Bytes += usage.Bytes
+		byDate[dir.ModTime] = total
+	}
+	dates := make([]int64, 0, len(byDate))
+	for date := range byDate {
+		dates = append(dates, date)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i] < dates[j] })
+
+	for _, date := range dates {
+		usage := byDate[date]
+		key := formatDateKey(date)
+		year, month, day := key[0:4], key[4:6], key[6:8]
+
+		if n := len(tree.Years); n == 0 || tree.Years[n-1].Year != year {
+			tree.Years = append(tree.Years, YearTree{Year: year})
+		}
+		y := &tree.Years[len(tree.Years)-1]
+		if n := len(y.Months); n == 0 || y.Months[n-1].Month != month {
+			y.Months = append(y.Months, MonthTree{Month: month})
+		}
+		m := &y.Months[len(y.Months)-1]
+		m.Days = append(m.Days, DayTree{Day: day, Files: usage.Files, Bytes: usage.Bytes})
+
+		m.Files += usage.Files
+		m.Bytes += usage.Bytes
+		y.Files += usage.Files
+		y.Bytes += usage.Bytes
+		tree.Files += usage.Files
+		tree.Bytes += usage.Bytes
+	}
+	return tree, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles creates files of the given sizes below root.
+func writeFiles(t *testing.T, root string, sizes map[string]int) {
+	t.Helper()
+	for name, size := range sizes {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuildTree(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]int
+		dirs  []string
+		want  []YearTree
+	}{
+		{
+			name: "empty",
+			want: []YearTree{},
+		},
+		{
+			name:  "one day",
+			files: map[string]int{"2025/03/14/a": 10, "2025/03/14/b": 5},
+			want: []YearTree{{Year: "2025", Files: 2, Bytes: 15, Months: []MonthTree{
+				{Month: "03", Files: 2, Bytes: 15, Days: []DayTree{{Day: "14", Files: 2, Bytes: 15}}},
+			}}},
+		},
+		{
+			name:  "years and months in order",
+			files: map[string]int{"2025/01/02/a": 1, "2024/12/31/a": 2, "2025/02/01/a": 3, "2025/01/01/a": 4},
+			want: []YearTree{
+				{Year: "2024", Files: 1, Bytes: 2, Months: []MonthTree{
+					{Month: "12", Files: 1, Bytes: 2, Days: []DayTree{{Day: "31", Files: 1, Bytes: 2}}},
+				}},
+				{Year: "2025", Files: 3, Bytes: 8, Months: []MonthTree{
+					{Month: "01", Files: 2, Bytes: 5, Days: []DayTree{{Day: "01", Files: 1, Bytes: 4}, {Day: "02", Files: 1, Bytes: 1}}},
+					{Month: "02", Files: 1, Bytes: 3, Days: []DayTree{{Day: "01", Files: 1, Bytes: 3}}},
+				}},
+			},
+		},
+		{
+			name:  "year before 1000",
+			files: map[string]int{"0012/03/15/a": 7},
+			want: []YearTree{{Year: "0012", Files: 1, Bytes: 7, Months: []MonthTree{
+				{Month: "03", Files: 1, Bytes: 7, Days: []DayTree{{Day: "15", Files: 1, Bytes: 7}}},
+			}}},
+		},
+		{
+			name:  "empty day and other directories",
+			files: map[string]int{"quarantine/x": 1, "2025/03/14/a": 1},
+			dirs:  []string{"2025/03/15", "2025/13/01"},
+			want: []YearTree{{Year: "2025", Files: 1, Bytes: 1, Months: []MonthTree{
+				{Month: "03", Files: 1, Bytes: 1, Days: []DayTree{{Day: "14", Files: 1, Bytes: 1}, {Day: "15"}}},
+			}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, test.files)
+			for _, dir := range test.dirs {
+				if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tree, err := buildTree(&Config{}, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tree.Years, test.want) {
+				t.Errorf("buildTree years = %+v, want %+v", tree.Years, test.want)
+			}
+			var files, bytes int64
+			for _, year := range test.want {
+				files += year.Files
+				bytes += year.Bytes
+			}
+			if tree.Files != files || tree.Bytes != bytes {
+				t.Errorf("buildTree totals = %d files, %d bytes, want %d, %d", tree.Files, tree.Bytes, files, bytes)
+			}
+		})
+	}
+}
+
+func TestBuildTreeCache(t *testing.T) {
+	cfg := &Config{YAMLConfig: YAMLConfig{FileTemplates: []StructTemplate{{Destination: "{yyyy}/{mm}/{dd}/{hh}"}}}}
+	root := t.TempDir()
+	totals := func() (int64, int64) {
+		t.Helper()
+		tree, err := buildTree(cfg, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree.Files, tree.Bytes
+	}
+	steps := []struct {
+		name  string
+		files map[string]int
+		wantF int64
+		wantB int64
+	}{
+		{"first walk", map[string]int{"2025/03/14/10/a": 1}, 1, 1},
+		{"unchanged", nil, 1, 1},
+		{"file in a known directory", map[string]int{"2025/03/14/10/b": 2}, 2, 3},
+		{"new directory in a day", map[string]int{"2025/03/14/11/a": 4}, 3, 7},
+		{"new day", map[string]int{"2025/03/15/00/a": 8}, 4, 15},
+	}
+	for _, step := range steps {
+		writeFiles(t, root, step.files)
+		if files, bytes := totals(); files != step.wantF || bytes != step.wantB {
+			t.Errorf("%s: buildTree totals = %d files, %d bytes, want %d, %d", step.name, files, bytes, step.wantF, step.wantB)
+		}
+	}
+
+	day := filepath.Join(root, "2025", "03", "14")
+	if err := os.RemoveAll(day); err != nil {
+		t.Fatal(err)
+	}
+	if files, bytes := totals(); files != 1 || bytes != 8 {
+		t.Errorf("removed day: buildTree totals = %d files, %d bytes, want 1, 8", files, bytes)
+	}
+	dayUsageMutex.Lock()
+	_, cached := dayUsageCache[day]
+	dayUsageMutex.Unlock()
+	if cached {
+		t.Errorf("removed day %s is still cached", day)
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigProblem is a problem found in a configuration file. Warnings concern
+// the environment, like a basepath that does not exist (yet); they do not
+// stop the service from starting.
+type ConfigProblem struct {
+	Line    int
+	Message string
+	Warning bool
+}
+
+func (p ConfigProblem) String() string {
+	severity := "error"
+	if p.Warning {
+		severity = "warning"
+	}
+	return fmt.Sprintf("%d: %s: %s", p.Line, severity, p.Message)
+}
+
+// yamlLineError matches the line number in the errors of the YAML decoder.
+var yamlLineError = regexp.MustCompile(`line (\d+): (.*)`)
+
+// validateConfig decodes a configuration strictly and checks it. It returns
+// the decoded configuration and every problem found, sorted by line.
+func validateConfig(data []byte) (YAMLConfig, []ConfigProblem) {
+	var config YAMLConfig
+	var problems []ConfigProblem
+	add := func(line int, warning bool, format string, args ...any) {
+		problems = append(problems, ConfigProblem{Line: line, Message: fmt.Sprintf(format, args...), Warning: warning})
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		line, message := splitYAMLError(err.Error())
+		add(line, false, "%s", message)
+		return config, problems
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		var typeError *yaml.TypeError
+		if errors.As(err, &typeError) {
+			for _, message := range typeError.Errors {
+				line, message := splitYAMLError(message)
+				add(line, false, "%s", message)
+			}
+		} else {
+			line, message := splitYAMLError(err.Error())
+			add(line, false, "%s", message)
+		}
+	}
+
+	var document *yaml.Node
+	if len(root.Content) > 0 {
+		document = root.Content[0]
+	}
+
+	// File templates
+	templates := lookupNode(document, "filetemplates")
+	if len(config.FileTemplates) == 0 {
+		add(lineOf(templates, document), false, "no file templates configured")
+	}
+	for i, template := range config.FileTemplates {
+		node := itemNode(templates, i)
+		line := lineOf(node, templates)
+
+		if template.Regex != "" && template.FileTemplate != "" {
+			add(line, false, "template %d: use either filetemplate or regex, not both", i+1)
+		}
+		if template.Regex == "" {
+			if template.FileTemplate == "" {
+				add(line, false, "template %d: filetemplate or regex is required", i+1)
+			}
+			if template.DateLayout != "YYYYMMDD" && template.DateLayout != "YYYYDDD" {
+				add(lineOf(lookupNode(node, "datelayout"), node), false,
+					"template %d: datelayout %q is not YYYYMMDD or YYYYDDD", i+1, template.DateLayout)
+			}
+			startLine := lineOf(lookupNode(node, "startdate"), node)
+			if template.StartDate < 0 {
+				add(startLine, false, "template %d: startdate %d is negative", i+1, template.StartDate)
+			} else if prefix := literalPrefix(template.FileTemplate); template.StartDate < len(prefix) {
+				add(startLine, false, "template %d: startdate %d falls inside the literal prefix %q of %s",
+					i+1, template.StartDate, prefix, template.FileTemplate)
+			}
+		}
+		if template.Cadence < 0 || template.MaxGap < 0 {
+			add(line, false, "template %d: cadence and maxgap must not be negative", i+1)
+		} else if template.MaxGap > 0 && template.MaxGap < template.Cadence {
+			add(lineOf(lookupNode(node, "maxgap"), node), false,
+				"template %d: maxgap %d is shorter than the cadence %d", i+1, template.MaxGap, template.Cadence)
+		}
+		if _, err := compileFileTemplate(template); err != nil {
+			add(line, false, "template %d: %v", i+1, err)
+		}
+	}
+
+	// Base paths
+	basepaths := lookupNode(document, "basepaths")
+	for i, bp := range config.BasePaths {
+		line := lineOf(itemNode(basepaths, i), basepaths)
+		if bp.Path == "" {
+			add(line, false, "basepath %d: path is required", i+1)
+			continue
+		}
+		if !filepath.IsAbs(bp.Path) {
+			add(line, false, "basepath %s is not an absolute path", bp.Path)
+		}
+		checkDirectory(line, bp.Path, add)
+		if bp.DestinationRoot != "" {
+			checkDirectory(line, bp.DestinationRoot, add)
+		}
+		if bp.Quarantine != nil && bp.Quarantine.RetentionDays < 0 {
+			add(line, false, "basepath %s: quarantine retentiondays is negative", bp.Path)
+		}
+		if bp.Archive != nil && bp.Archive.Directory != "" {
+			if !filepath.IsAbs(bp.Archive.Directory) {
+				add(line, false, "basepath %s: archive directory %s is not an absolute path", bp.Path, bp.Archive.Directory)
+			} else if onDisk(bp.Archive.Directory, bp.destinationRoot()) {
+				add(line, true, "basepath %s: archive directory %s is on the same filesystem, archiving frees little space", bp.Path, bp.Archive.Directory)
+			}
+		}
+		if bp.Weight < 0 {
+			add(line, false, "basepath %s: weight is negative", bp.Path)
+		}
+		retention := bp.Retention
+		switch {
+		case retention.MaxDays < 0 || retention.MinDays < 0:
+			add(line, false, "basepath %s: retention maxdays and mindays must not be negative", bp.Path)
+		case retention.Forever && retention.MaxDays > 0:
+			add(line, false, "basepath %s: retention forever cannot be combined with maxdays", bp.Path)
+		case retention.MaxDays > 0 && retention.MinDays > retention.MaxDays:
+			add(line, false, "basepath %s: retention mindays %d is larger than maxdays %d", bp.Path, retention.MinDays, retention.MaxDays)
+		}
+	}
+
+	// Disks
+	disks := lookupNode(document, "disks")
+	for i, thedisk := range config.Disks {
+		node := itemNode(disks, i)
+		line := lineOf(node, disks)
+		if thedisk.FreeDiskSpace < 0 || thedisk.FreeDiskSpace > 100 {
+			add(lineOf(lookupNode(node, "freediskspace"), node), false,
+				"disk %s: freediskspace %d is not a percentage", thedisk.DiskName, thedisk.FreeDiskSpace)
+		}
+		if thedisk.FreeDiskSpace > 0 && thedisk.MinFree != "" {
+			add(line, false, "disk %s: use either freediskspace or minfree, not both", thedisk.DiskName)
+		}
+		if limits, err := limitsFor(thedisk); err != nil {
+			add(line, false, "disk %s: %v", thedisk.DiskName, err)
+		} else {
+			checkLimits(line, thedisk.DiskName, "free space", limits.minFree, limits.targetFree, add)
+			checkLimits(line, thedisk.DiskName, "free inodes", limits.minInodes, limits.targetInodes, add)
+		}
+		if err := checkEvictionStrategy(thedisk.Eviction); err != nil {
+			add(lineOf(lookupNode(node, "eviction"), node), false, "disk %s: %v", thedisk.DiskName, err)
+		}
+		used := false
+		for _, bp := range config.BasePaths {
+			if onDisk(bp.destinationRoot(), thedisk.DiskName) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			add(line, false, "disk %s holds no basepath, it would never be cleaned", thedisk.DiskName)
+		}
+		if ok, err := isMountPoint(thedisk.DiskName); err != nil {
+			add(line, true, "disk %s: %v", thedisk.DiskName, err)
+		} else if !ok {
+			add(line, true, "disk %s is not a mount point", thedisk.DiskName)
+		}
+	}
+
+	// Other settings
+	if config.PortNumber != "" {
+		port, err := strconv.Atoi(config.PortNumber)
+		if err != nil || port < 1 || port > 65535 {
+			add(lineOf(lookupNode(document, "portnumber"), document), false, "portnumber %q is not a valid port", config.PortNumber)
+		}
+	}
+	if config.ListenAddress != "" {
+		line := lineOf(lookupNode(document, "listenaddress"), document)
+		if ip := net.ParseIP(config.ListenAddress); ip == nil {
+			if strings.Contains(config.ListenAddress, ":") {
+				add(line, false, "listenaddress %q must not include a port, use portnumber", config.ListenAddress)
+			}
+		} else if !ip.IsUnspecified() && !isLocalAddress(ip) {
+			add(line, true, "listenaddress %s is not an address of this host", config.ListenAddress)
+		}
+	}
+	if config.AuditLog.MaxSize < 0 || config.AuditLog.Keep < 0 {
+		add(lineOf(lookupNode(document, "auditlog"), document), false, "auditlog maxsize and keep must not be negative")
+	}
+	if node := lookupNode(document, "notifications"); node != nil {
+		checkNotifications(config.Notifications, node, add)
+	}
+	if config.ScanInterval < 0 {
+		add(lineOf(lookupNode(document, "scaninterval"), document), false, "scaninterval is negative")
+	}
+	if config.SettleWindow < 0 {
+		add(lineOf(lookupNode(document, "settlewindow"), document), false, "settlewindow is negative")
+	}
+	if config.Quarantine.RetentionDays < 0 {
+		add(lineOf(lookupNode(document, "quarantine"), document), false, "quarantine retentiondays is negative")
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return config, problems
+}
+
+// runValidate implements the validate command. It prints every problem in
+// the configuration file and returns the exit status.
+func runValidate(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("%s: %v\n", path, err)
+		return 1
+	}
+
+	_, problems := validateConfig(data)
+	errorCount := 0
+	for _, problem := range problems {
+		fmt.Printf("%s:%s\n", path, problem)
+		if !problem.Warning {
+			errorCount++
+		}
+	}
+	fmt.Printf("%s: %d error(s), %d warning(s)\n", path, errorCount, len(problems)-errorCount)
+	if errorCount > 0 {
+		return 1
+	}
+	return 0
+}
+
+// splitYAMLError separates the line number from a YAML error message.
+func splitYAMLError(message string) (int, string) {
+	message = strings.TrimPrefix(message, "yaml: ")
+	if match := yamlLineError.FindStringSubmatch(message); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		return line, match[2]
+	}
+	return 0, message
+}
+
+// lookupNode returns the value of key in a mapping node, or nil.
+func lookupNode(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// itemNode returns item i of a sequence node, or nil.
+func itemNode(node *yaml.Node, i int) *yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+		return nil
+	}
+	return node.Content[i]
+}
+
+// lineOf returns the line of node, or of parent when node is missing.
+func lineOf(node, parent *yaml.Node) int {
+	if node != nil {
+		return node.Line
+	}
+	if parent != nil {
+		return parent.Line
+	}
+	return 0
+}
+
+// literalPrefix returns the part of a glob-like file template before its
+// first wildcard. Both "*" and "." match any character.
+func literalPrefix(template string) string {
+	if i := strings.IndexAny(template, "*."); i >= 0 {
+		return template[:i]
+	}
+	return template
+}
+
+// isBelow reports whether path is dir or lies below it.
+func isBelow(path, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// checkDirectory reports a warning when dir does not exist or is not a
+// directory.
+func checkDirectory(line int, dir string, add func(int, bool, string, ...any)) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		add(line, true, "%s: %v", dir, errors.Unwrap(err))
+		return
+	}
+	if !info.IsDir() {
+		add(line, true, "%s is not a directory", dir)
+	}
+}
+
+// checkLimits reports a target below its minimum. Thresholds of a different
+// kind, absolute and percentage, can only be compared on the disk itself.
+func checkLimits(line int, diskName, what string, minimum, target threshold, add func(int, bool, string, ...any)) {
+	if target.set() && !minimum.set() {
+		add(line, false, "disk %s: a target for %s needs a minimum", diskName, what)
+		return
+	}
+	if minimum.Percent == target.Percent && target.Value < minimum.Value {
+		add(line, false, "disk %s: target for %s is below its minimum", diskName, what)
+	}
+}
+
+// checkNotifications reports webhooks that are not HTTP URLs and an
+// incomplete SMTP relay.
+func checkNotifications(config StructNotifications, node *yaml.Node, add func(int, bool, string, ...any)) {
+	line := lineOf(node, node)
+	webhooks := lookupNode(node, "webhooks")
+	for i, webhook := range config.Webhooks {
+		u, err := url.Parse(webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(lineOf(itemNode(webhooks, i), webhooks), false, "notifications: webhook %q is not an http or https URL", webhook)
+		}
+	}
+
+	smtpConfig := config.SMTP
+	if smtpNode := lookupNode(node, "smtp"); smtpNode != nil {
+		smtpLine := lineOf(smtpNode, node)
+		if smtpConfig.Server == "" {
+			add(smtpLine, false, "notifications: smtp server is required")
+		} else if _, _, err := net.SplitHostPort(smtpConfig.Server); err != nil {
+			add(smtpLine, false, "notifications: smtp server %q is not host:port", smtpConfig.Server)
+		}
+		if smtpConfig.From == "" || len(smtpConfig.To) == 0 {
+			add(smtpLine, false, "notifications: smtp from and to are required")
+		}
+		if smtpConfig.Password != "" && smtpConfig.Username == "" {
+			add(smtpLine, true, "notifications: smtp password is ignored without a username")
+		}
+	}
+
+	if config.Interval < 0 || config.MaxPerHour < 0 || config.UnmatchedSpike < 0 {
+		add(line, false, "notifications: interval, maxperhour and unmatchedspike must not be negative")
+	}
+	if len(config.Webhooks) == 0 && smtpConfig.Server == "" {
+		add(line, true, "notifications: no webhooks or smtp server, nothing is sent")
+	}
+}
+
+// isLocalAddress reports whether ip is assigned to one of the interfaces of
+// this host.
+func isLocalAddress(ip net.IP) bool {
+	addresses, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addresses {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMountPoint reports whether path is the root of a mounted filesystem, by
+// comparing its device with the device of its parent.
+func isMountPoint(path string) (bool, error) {
+	path = filepath.Clean(path)
+	if path == "/" {
+		return true, nil
+	}
+	var stat, parentStat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return false, err
+	}
+	if err := syscall.Stat(filepath.Dir(path), &parentStat); err != nil {
+		return false, err
+	}
+	return stat.Dev != parentStat.Dev || stat.Ino == parentStat.Ino, nil
+}
+
+// unknownFields reports the keys of a mapping, and of the mappings nested in
+// it, that match no field of the struct type t, in the form of the errors of
+// the strict decoder. A custom UnmarshalYAML uses it, because decoding its
+// node does not inherit the strict checking of the decoder.
+func unknownFields(node *yaml.Node, t reflect.Type) error {
+	var messages []string
+	collectUnknownFields(node, t, &messages)
+	if len(messages) == 0 {
+		return nil
+	}
+	return &yaml.TypeError{Errors: messages}
+}
+
+func collectUnknownFields(node *yaml.Node, t reflect.Type, messages *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for _, item := range node.Content {
+			collectUnknownFields(item, t.Elem(), messages)
+		}
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			fields[name] = field.Type
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				*messages = append(*messages, fmt.Sprintf("line %d: field %s not found in type %s", key.Line, key.Value, t))
+				continue
+			}
+			collectUnknownFields(value, fieldType, messages)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigUnknownBasePathFields(t *testing.T) {
+	data := `filetemplates:
+  - filetemplate: "avhrr_*_noaa19.hrp.bz2"
+    startdate: 6
+    datelayout: YYYYMMDD
+basepaths:
+  - /data/a
+  - path: /data/b
+    retenton:
+      maxdays: 3
+    archive:
+      directry: /archive/b
+    retention:
+      maxdays: 3
+    quarantine:
+      directory: /quarantine/b
+`
+	want := map[int]string{
+		8:  "field retenton not found",
+		11: "field directry not found",
+	}
+	_, problems := validateConfig([]byte(data))
+	for _, problem := range problems {
+		if problem.Warning {
+			continue
+		}
+		message, ok := want[problem.Line]
+		if !ok || !strings.Contains(problem.Message, message) {
+			t.Errorf("unexpected problem %s", problem)
+			continue
+		}
+		delete(want, problem.Line)
+	}
+	for line, message := range want {
+		t.Errorf("line %d: missing problem %q", line, message)
+	}
+}
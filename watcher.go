@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// basePathWatcher uses inotify to file products as soon as they have been
+// written to, or moved into, one of the basepaths.
+type basePathWatcher struct {
+	fd      int
+	file    *os.File // The nonblocking fd, read through the runtime poller
+	mu      sync.Mutex
+	watches map[int32]string // Watch descriptor to basepath
+	missing map[string]bool  // Basepaths that cannot be watched
+}
+
+// newBasePathWatcher creates an inotify instance and adds a watch for every
+// basepath. A basepath that cannot be watched is only reported; the periodic
+// sweep still takes care of it.
+func newBasePathWatcher() (*basePathWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init: %v", err)
+	}
+	w := &basePathWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[int32]string),
+		missing: make(map[string]bool),
+	}
+	w.syncWatches()
+	return w, nil
+}
+
+// syncWatches adds a watch for every configured basepath and removes the
+// watches of basepaths that are no longer configured. It runs on every sweep,
+// so that a basepath that did not exist or was not mounted is watched as soon
+// as it is back. Adding a watch that exists already only returns it again.
+func (w *basePathWatcher) syncWatches() {
+	cfg := currentConfig()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	configured := make(map[string]bool)
+	for _, bp := range cfg.BasePaths {
+		configured[bp.Path] = true
+	}
+	for wd, path := range w.watches {
+		if !configured[path] {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+		}
+	}
+	for path := range w.missing {
+		if !configured[path] {
+			delete(w.missing, path)
+		}
+	}
+
+	for _, bp := range cfg.BasePaths {
+		wd, err := syscall.InotifyAddWatch(w.fd, bp.Path, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_ONLYDIR)
+		if err != nil {
+			if !w.missing[bp.Path] {
+				fmt.Printf("Warning: cannot watch %s, sweeping it instead: %v\n", bp.Path, err)
+				w.missing[bp.Path] = true
+			}
+			continue
+		}
+		if w.missing[bp.Path] {
+			fmt.Printf("Watching %s again\n", bp.Path)
+			delete(w.missing, bp.Path)
+		}
+		w.watches[int32(wd)] = bp.Path
+	}
+}
+
+// watchingAll reports whether every configured basepath is watched.
+func (w *basePathWatcher) watchingAll() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.missing) == 0
+}
+
+// lost forgets a watch that the kernel removed, because its basepath was
+// deleted or unmounted, and tries to watch the basepath again.
+func (w *basePathWatcher) lost(wd int32) {
+	w.mu.Lock()
+	path, ok := w.watches[wd]
+	delete(w.watches, wd)
+	w.mu.Unlock()
+	if !ok {
+		return // Removed by syncWatches
+	}
+	fmt.Printf("Warning: watch of %s lost\n", path)
+	w.syncWatches()
+}
+
+// run reads inotify events until done is closed and files every file that
+// was closed after writing or moved into a basepath.
+func (w *basePathWatcher) run(done chan bool) {
+	// Closing the file stops a pending read.
+	go func() {
+		<-done
+		w.file.Close()
+	}()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				fmt.Printf("Error reading inotify events: %v\n", err)
+			}
+			return
+		}
+
+		parseInotifyEvents(buf[:n], func(event *syscall.InotifyEvent, name string) {
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				// Events were lost, let a full sweep catch up.
+				fmt.Println("Warning: inotify queue overflow, sweeping all basepaths")
+				if err := moveFilesToDateSubdirs(); err != nil {
+					fmt.Printf("Error moveFilesToDateSubdirs: %v\n", err)
+				}
+				return
+			}
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				// Sent after the basepath was deleted or unmounted.
+				w.lost(event.Wd)
+				return
+			}
+			if event.Mask&syscall.IN_ISDIR != 0 || name == "" {
+				return
+			}
+
+			w.mu.Lock()
+			basepath, ok := w.watches[event.Wd]
+			w.mu.Unlock()
+			if ok {
+				w.handle(basepath, name)
+			}
+		})
+	}
+}
+
+// parseInotifyEvents calls fn for every event in a buffer read from an
+// inotify file descriptor, together with the name of the file concerned.
+func parseInotifyEvents(buf []byte, fn func(event *syscall.InotifyEvent, name string)) {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+		offset += syscall.SizeofInotifyEvent + int(event.Len)
+		fn(event, string(bytes.TrimRight(nameBytes, "\x00")))
+	}
+}
+
+// handle files a single file reported by inotify.
+func (w *basePathWatcher) handle(basepath, filename string) {
+	cfg := currentConfig()
+	for _, bp := range cfg.BasePaths {
+		if bp.Path != basepath {
+			continue
+		}
+
+		moveMutex.Lock()
+		var report dryRunReport
+		err := moveFile(cfg, bp, filename, &report)
+		moveMutex.Unlock()
+
+		if cfg.DryRun {
+			report.print("watcher")
+		}
+		if errors.Is(err, errNotSettled) {
+			// Look again once the settle window has passed.
+			time.AfterFunc(time.Duration(cfg.SettleWindow)*time.Second, func() {
+				w.handle(basepath, filename)
+			})
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error moving %s: %v\n", filename, err)
+			addMoveError(newMoveError(basepath, filename, err))
+		}
+		return
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBasePathWatcherLostAndShutdown(t *testing.T) {
+	basepath := filepath.Join(t.TempDir(), "in")
+	configMutex.Lock()
+	yamlconfig = YAMLConfig{BasePaths: []StructBasePath{{Path: basepath}}}
+	configMutex.Unlock()
+	defer func() { yamlconfig = YAMLConfig{} }()
+
+	w, err := newBasePathWatcher()
+	if err != nil {
+		t.Skipf("inotify not available: %v", err)
+	}
+	if w.watchingAll() {
+		t.Fatal("missing basepath counts as watched")
+	}
+
+	// A basepath that appears is watched at the next sweep.
+	if err := os.Mkdir(basepath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	w.syncWatches()
+	if !w.watchingAll() {
+		t.Fatal("basepath not watched after it was created")
+	}
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		w.run(done)
+		close(stopped)
+	}()
+
+	// A deleted basepath loses its watch.
+	if err := os.Remove(basepath); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for w.watchingAll() {
+		if time.Now().After(deadline) {
+			t.Fatal("deleted basepath still counts as watched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after done was closed")
+	}
+}